@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	reporter "github.com/niklc/listing-reporter/internal"
+)
+
+func main() {
+	err := reporter.ConfigureLogging(reporter.JsonLogFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	token := os.Getenv("API_TOKEN")
+	if token == "" {
+		log.Fatal("API_TOKEN must be set")
+	}
+
+	store, err := reporter.NewRulesStoreFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The API serves the links itself and can't be given its own function URL
+	// without a dependency cycle, so checking them only needs the secret.
+	links := reporter.NewSubscriptionLinksFromEnv()
+	if secret := os.Getenv("SUBSCRIPTION_SECRET"); links == nil && secret != "" {
+		links = reporter.NewSubscriptionLinks("", []byte(secret))
+	}
+	handler := reporter.NewHttpHandler(store, token, links)
+
+	lambda.Start(reporter.NewLambdaHttpFunc(handler))
+}
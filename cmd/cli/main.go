@@ -1,14 +1,21 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws/session"
 	_ "github.com/joho/godotenv/autoload"
 	reporter "github.com/niklc/listing-reporter/internal"
 )
@@ -17,12 +24,81 @@ func main() {
 	if len(os.Args) < 2 {
 		log.Fatal("provide subcommand")
 	}
+	err := reporter.ConfigureLogging(reporter.TextLogFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := reporter.ConfigureTracing(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+	defer reporter.CloseStores()
 
 	switch os.Args[1] {
 	case "run":
+		flags := flag.NewFlagSet("run", flag.ExitOnError)
+		dryRun := flags.Bool("dry-run", false, "print listings and cutoff changes without sending emails or updating rules")
+		mode := flags.String("mode", reporter.ScrapeMode, "scrape, or flush-outbox to only deliver pending notifications")
+		rules := flags.String("rule", "", "only process the rules with these comma separated names")
+		tags := flags.String("tag", "", "only process rules with any of these comma separated tags")
+		outDir := flags.String("out", "", "with -dry-run, write emails as .eml and .html files to this directory")
+		defaultInterval := flags.Duration("default-interval", 0, "check interval for rules without one, 0 checks them on every run")
+		fetchSpread := flags.Duration("fetch-spread", 0, "spread site fetches over this duration")
+		flags.Parse(os.Args[2:])
+
 		start := time.Now()
-		reporter.Run()
+		_, err := reporter.Run(ctx, reporter.RunOptions{
+			Mode:            *mode,
+			DryRun:          *dryRun,
+			Rules:           splitList(*rules),
+			Tags:            splitList(*tags),
+			OutDir:          *outDir,
+			DefaultInterval: *defaultInterval,
+			FetchSpread:     *fetchSpread,
+		})
 		fmt.Printf("Execution time: %s\n", time.Since(start))
+		shutdownTracing(context.Background())
+		reporter.CloseStores()
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "serve":
+		flags := flag.NewFlagSet("serve", flag.ExitOnError)
+		addr := flags.String("addr", ":8080", "health and metrics endpoint listen address")
+		defaultInterval := flags.Duration("default-interval", 15*time.Minute, "check interval for rules without one")
+		flags.Parse(os.Args[2:])
+
+		runner, err := reporter.NewReporterFromEnv(ctx, false)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		daemon := reporter.NewDaemon(runner, *defaultInterval)
+
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", daemon)
+		mux.Handle("/metrics", daemon.Metrics())
+		if token := os.Getenv("API_TOKEN"); token != "" {
+			mux.Handle("/", reporter.NewHttpHandler(runner.Rules(), token, reporter.NewSubscriptionLinksFromEnv()))
+		}
+		server := &http.Server{Addr: *addr, Handler: mux}
+		go func() {
+			err := server.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+		fmt.Printf("Listening on %s\n", *addr)
+
+		daemon.Run(ctx)
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
 	case "generate-token":
 		file, err := os.Open("credentials.json")
 		if err != nil {
@@ -37,12 +113,22 @@ func main() {
 
 		reporter.GetAndSaveToken(data)
 	case "get-rules":
-		store := reporter.NewRulesStore(session.Must(session.NewSession()))
-		rules, err := store.Get()
+		flags := flag.NewFlagSet("get-rules", flag.ExitOnError)
+		tags := flags.String("tag", "", "only print rules with any of these comma separated tags")
+		flags.Parse(os.Args[2:])
+
+		store, err := reporter.NewRulesStoreFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		rules, err := store.Get(ctx)
 		if err != nil {
 			log.Fatal(err)
 		}
 		for _, rule := range rules {
+			if *tags != "" && !rule.HasAnyTag(splitList(*tags)) {
+				continue
+			}
 			filters, err := json.Marshal(rule)
 			if err != nil {
 				log.Println("print rule failed: ", err)
@@ -51,16 +137,35 @@ func main() {
 			fmt.Println(string(filters))
 		}
 	case "put-rule":
-		if len(os.Args) < 3 {
+		flags := flag.NewFlagSet("put-rule", flag.ExitOnError)
+		update := flags.Bool("update", false, "replace the stored rule with the same name")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() < 1 {
 			log.Fatal("provide rule")
 		}
-		rule := reporter.RetrievalRule{}
-		err := json.Unmarshal([]byte(os.Args[2]), &rule)
+		rule, err := reporter.DecodeRule([]byte(flags.Arg(0)))
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = rule.Validate()
+		if err != nil {
+			log.Fatal(err)
+		}
+		store, err := reporter.NewRulesStoreFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		existing, err := store.GetOne(ctx, rule.Name)
 		if err != nil {
 			log.Fatal(err)
 		}
-		store := reporter.NewRulesStore(session.Must(session.NewSession()))
-		err = store.Put(rule)
+		if existing != nil && rule.Version == 0 {
+			if !*update {
+				log.Fatalf("rule %s already exists, pass its Version or -update to replace it", rule.Name)
+			}
+			rule.Version = existing.Version
+		}
+		err = store.Put(ctx, rule)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -68,12 +173,210 @@ func main() {
 		if len(os.Args) < 3 {
 			log.Fatal("provide rule name")
 		}
-		store := reporter.NewRulesStore(session.Must(session.NewSession()))
-		err := store.Delete(os.Args[2])
+		store, err := reporter.NewRulesStoreFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = store.Delete(ctx, os.Args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "export-rules":
+		if len(os.Args) < 3 {
+			log.Fatal("provide rules dir")
+		}
+		store, err := reporter.NewRulesStoreFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		rules, err := store.Get(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = reporter.ExportRules(os.Args[2], rules)
 		if err != nil {
 			log.Fatal(err)
 		}
+		fmt.Printf("Exported %d rules to %s\n", len(rules), os.Args[2])
+	case "sync-rules":
+		flags := flag.NewFlagSet("sync-rules", flag.ExitOnError)
+		yes := flags.Bool("yes", false, "apply the plan without asking for confirmation")
+		prune := flags.Bool("prune", false, "delete stored rules that have no file in the dir")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() < 1 {
+			log.Fatal("provide rules dir")
+		}
+
+		desired, err := reporter.LoadRuleDefinitions(flags.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		store, err := reporter.NewRulesStoreFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		current, err := store.Get(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		changes, err := reporter.PlanRuleSync(current, desired, *prune)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(changes) == 0 {
+			fmt.Println("Rules are up to date")
+			return
+		}
+
+		printRuleChanges(changes)
+
+		if !*yes {
+			fmt.Print("Apply these changes? [y/N] ")
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			if strings.TrimSpace(strings.ToLower(answer)) != "y" {
+				fmt.Println("Cancelled")
+				return
+			}
+		}
+		applied, err := reporter.ApplyRuleSync(ctx, store, changes)
+		if err != nil {
+			if len(applied) > 0 {
+				fmt.Println("Applied before the failure:")
+				printRuleChanges(applied)
+			}
+			log.Fatal(err)
+		}
+		fmt.Printf("Applied %d changes\n", len(changes))
+	case "lint-rules":
+		store, err := reporter.NewRulesStoreFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		rules, err := store.Get(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config, err := reporter.FetcherConfigFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		failed := 0
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "rule\tlistings\tproblems")
+		for _, result := range reporter.LintRules(ctx, reporter.NewFetcher(config), rules) {
+			problems := "ok"
+			if len(result.Problems) > 0 {
+				failed++
+				problems = strings.Join(result.Problems, "; ")
+			}
+			fmt.Fprintf(writer, "%s\t%d\t%s\n", result.Rule, result.Listings, problems)
+		}
+		writer.Flush()
+		if failed > 0 {
+			log.Fatalf("%d of %d rules have problems", failed, len(rules))
+		}
+	case "enable-rule", "disable-rule":
+		if len(os.Args) < 3 {
+			log.Fatal("provide rule name")
+		}
+		store, err := reporter.NewRulesStoreFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		rule, err := store.GetOne(ctx, os.Args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if rule == nil {
+			log.Fatalf("rule not found: %s", os.Args[2])
+		}
+		enabled := os.Args[1] == "enable-rule"
+		rule.Enabled = &enabled
+		err = store.Put(ctx, *rule)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "parse-url":
+		if len(os.Args) < 3 {
+			log.Fatal("provide ss.lv url")
+		}
+		path, filters, err := reporter.FiltersFromUrl(os.Args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		rule, err := json.Marshal(reporter.RetrievalRule{Url: path, Filters: filters})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(rule))
+	case "capture-fixture":
+		flags := flag.NewFlagSet("capture-fixture", flag.ExitOnError)
+		dir := flags.String("dir", reporter.FixturesDir, "fixtures directory")
+		name := flags.String("name", "", "fixture name, derived from the url path by default")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() < 1 {
+			log.Fatal("provide ss.lv url")
+		}
+		base, err := reporter.CaptureFixture(ctx, flags.Arg(0), *dir, *name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Saved %s.html and %s.golden.json\n", base, base)
+	case "serve-subscriptions":
+		addr := ":8080"
+		if len(os.Args) >= 3 {
+			addr = os.Args[2]
+		}
+		links := reporter.NewSubscriptionLinksFromEnv()
+		if links == nil {
+			log.Fatal("SUBSCRIPTION_BASE_URL and SUBSCRIPTION_SECRET must be set")
+		}
+		store, err := reporter.NewRulesStoreFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		handler := reporter.NewSubscriptionHandler(links, store)
+		fmt.Printf("Listening on %s\n", addr)
+		log.Fatal(http.ListenAndServe(addr, handler))
+	case "serve-api":
+		addr := ":8080"
+		if len(os.Args) >= 3 {
+			addr = os.Args[2]
+		}
+		token := os.Getenv("API_TOKEN")
+		if token == "" {
+			log.Fatal("API_TOKEN must be set")
+		}
+		store, err := reporter.NewRulesStoreFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		handler := reporter.NewHttpHandler(store, token, reporter.NewSubscriptionLinksFromEnv())
+		fmt.Printf("Listening on %s\n", addr)
+		log.Fatal(http.ListenAndServe(addr, handler))
 	default:
 		log.Fatal("unknown command")
 	}
 }
+
+func printRuleChanges(changes []reporter.RuleChange) {
+	symbols := map[string]string{reporter.CreateRule: "+", reporter.UpdateRule: "~", reporter.DeleteRule: "-"}
+	for _, change := range changes {
+		fmt.Printf("%s %s", symbols[change.Action], change.Name)
+		if len(change.Fields) > 0 {
+			fmt.Printf(" (%s)", strings.Join(change.Fields, ", "))
+		}
+		fmt.Println()
+	}
+}
+
+func splitList(val string) []string {
+	items := []string{}
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -1,14 +1,81 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+
 	"github.com/aws/aws-lambda-go/lambda"
 	reporter "github.com/niklc/listing-reporter/internal"
 )
 
-func HandleRequest() {
-	reporter.Run()
+// Event selects what an invocation does. Scheduled events carry none of
+// these fields and run all due rules.
+type Event struct {
+	Mode   string   `json:"mode"`
+	Rules  []string `json:"rules"`
+	Tags   []string `json:"tags"`
+	DryRun bool     `json:"dry_run"`
+}
+
+func HandleRequest(ctx context.Context, event Event) (*reporter.RunSummary, error) {
+	defaultInterval, err := getDurationEnv("DEFAULT_INTERVAL")
+	if err != nil {
+		return nil, err
+	}
+	fetchSpread, err := getDurationEnv("FETCH_SPREAD")
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := reporter.Run(ctx, reporter.RunOptions{
+		Mode:            event.Mode,
+		DryRun:          event.DryRun,
+		Rules:           event.Rules,
+		Tags:            event.Tags,
+		DefaultInterval: defaultInterval,
+		FetchSpread:     fetchSpread,
+	})
+	if report != nil {
+		emfErr := reporter.WriteEmf(os.Stdout, report)
+		if emfErr != nil {
+			slog.Error("metrics emission failed", "error", emfErr)
+		}
+	}
+	flushErr := reporter.FlushTraces(context.WithoutCancel(ctx))
+	if flushErr != nil {
+		slog.Error("trace flush failed", "error", flushErr)
+	}
+	if report == nil {
+		return nil, err
+	}
+	summary := report.Summary()
+	return &summary, err
+}
+
+func getDurationEnv(name string) (time.Duration, error) {
+	val := os.Getenv(name)
+	if val == "" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return duration, nil
 }
 
 func main() {
+	err := reporter.ConfigureLogging(reporter.JsonLogFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = reporter.ConfigureTracing(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
 	lambda.Start(HandleRequest)
 }
@@ -0,0 +1,175 @@
+package reporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMinParseRatio = 0.8
+	defaultMaxEmptyRuns  = 3
+)
+
+type Alert struct {
+	Rule    string
+	Message string
+}
+
+type Alerter interface {
+	SendAlerts(ctx context.Context, alerts []Alert) error
+}
+
+type AlertConfig struct {
+	MinParseRatio float64
+	MaxEmptyRuns  int
+}
+
+func DefaultAlertConfig() AlertConfig {
+	return AlertConfig{MinParseRatio: defaultMinParseRatio, MaxEmptyRuns: defaultMaxEmptyRuns}
+}
+
+func AlertConfigFromEnv() (AlertConfig, error) {
+	config := DefaultAlertConfig()
+
+	if val := os.Getenv("ALERT_MIN_PARSE_RATIO"); val != "" {
+		ratio, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return config, fmt.Errorf("invalid ALERT_MIN_PARSE_RATIO: %w", err)
+		}
+		config.MinParseRatio = ratio
+	}
+
+	var err error
+	config.MaxEmptyRuns, err = getIntEnv("ALERT_MAX_EMPTY_RUNS", config.MaxEmptyRuns)
+	return config, err
+}
+
+// CheckParseHealth updates the parse health counters of the rule and returns
+// an alert only when a threshold is crossed, so a broken layout alerts once
+// instead of on every run.
+func CheckParseHealth(rule *RetrievalRule, stats ParseStats, config AlertConfig) *Alert {
+	if stats.Rows == 0 {
+		rule.EmptyRuns++
+		if rule.EmptyRuns == config.MaxEmptyRuns {
+			return &Alert{
+				Rule:    rule.Name,
+				Message: fmt.Sprintf("no listing rows found for %d runs in a row", rule.EmptyRuns),
+			}
+		}
+		return nil
+	}
+	rule.EmptyRuns = 0
+
+	wasDegraded := rule.ParseDegraded
+	rule.ParseDegraded = stats.SuccessRatio() < config.MinParseRatio
+	if !rule.ParseDegraded || wasDegraded {
+		return nil
+	}
+	return &Alert{
+		Rule: rule.Name,
+		Message: fmt.Sprintf(
+			"parsed %d of %d rows (%.0f%%), skipped: %s",
+			stats.Parsed,
+			stats.Rows-stats.Ignored,
+			stats.SuccessRatio()*100,
+			stats.SkippedSummary(),
+		),
+	}
+}
+
+func NewAlerterFromEnv(emailClient *EmailClient) Alerter {
+	alerters := multiAlerter{}
+	if to := os.Getenv("ALERT_EMAIL"); to != "" && emailClient != nil {
+		alerters = append(alerters, &EmailAlerter{client: emailClient, to: to})
+	}
+	if url := os.Getenv("ALERT_WEBHOOK_URL"); url != "" {
+		alerters = append(alerters, NewWebhookAlerter(url))
+	}
+	if len(alerters) == 0 {
+		return nil
+	}
+	return alerters
+}
+
+type multiAlerter []Alerter
+
+func (m multiAlerter) SendAlerts(ctx context.Context, alerts []Alert) error {
+	var err error
+	for _, alerter := range m {
+		err = errors.Join(err, alerter.SendAlerts(ctx, alerts))
+	}
+	return err
+}
+
+type EmailAlerter struct {
+	client *EmailClient
+	to     string
+}
+
+func (a *EmailAlerter) SendAlerts(ctx context.Context, alerts []Alert) error {
+	return a.client.send(ctx, RenderAlertEmail(a.to, alerts))
+}
+
+func RenderAlertEmail(to string, alerts []Alert) RenderedEmail {
+	body := "<ul>"
+	for _, alert := range alerts {
+		body += fmt.Sprintf("<li><b>%s</b>: %s</li>\n", html.EscapeString(alert.Rule), html.EscapeString(alert.Message))
+	}
+	body += "</ul>"
+
+	return RenderedEmail{
+		To:      to,
+		Subject: fmt.Sprintf("listing-reporter: %d parser alerts", len(alerts)),
+		Body:    body,
+	}
+}
+
+type WebhookAlerter struct {
+	url    string
+	client *http.Client
+}
+
+type webhookPayload struct {
+	Text   string  `json:"text"`
+	Alerts []Alert `json:"alerts"`
+}
+
+func NewWebhookAlerter(url string) *WebhookAlerter {
+	return &WebhookAlerter{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (a *WebhookAlerter) SendAlerts(ctx context.Context, alerts []Alert) error {
+	lines := make([]string, len(alerts))
+	for i, alert := range alerts {
+		lines[i] = alert.Rule + ": " + alert.Message
+	}
+	body, err := json.Marshal(webhookPayload{Text: strings.Join(lines, "\n"), Alerts: alerts})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("alert webhook failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("alert webhook failed: %w", &StatusError{Url: a.url, Code: res.StatusCode})
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package reporter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckParseHealthEmptyRuns(t *testing.T) {
+	config := AlertConfig{MinParseRatio: 0.8, MaxEmptyRuns: 3}
+	rule := RetrievalRule{Name: "a"}
+
+	alerts := 0
+	for range 5 {
+		if CheckParseHealth(&rule, ParseStats{}, config) != nil {
+			alerts++
+		}
+	}
+	if alerts != 1 || rule.EmptyRuns != 5 {
+		t.Errorf("Expected 1 alert after 5 empty runs, got %d alerts and %d empty runs", alerts, rule.EmptyRuns)
+	}
+
+	CheckParseHealth(&rule, ParseStats{Rows: 3, Parsed: 3}, config)
+	if rule.EmptyRuns != 0 {
+		t.Errorf("Expected empty runs reset, got %d", rule.EmptyRuns)
+	}
+}
+
+func TestCheckParseHealthRatio(t *testing.T) {
+	config := AlertConfig{MinParseRatio: 0.8, MaxEmptyRuns: 3}
+	rule := RetrievalRule{Name: "a"}
+	degraded := ParseStats{Rows: 10, Parsed: 5, Skipped: map[string]int{"price": 5}}
+
+	alert := CheckParseHealth(&rule, degraded, config)
+	if alert == nil || alert.Message != "parsed 5 of 10 rows (50%), skipped: price=5" {
+		t.Errorf("Unexpected alert: %+v", alert)
+	}
+	if CheckParseHealth(&rule, degraded, config) != nil {
+		t.Error("Expected no repeated alert while degraded")
+	}
+	if CheckParseHealth(&rule, ParseStats{Rows: 10, Parsed: 10}, config) != nil || rule.ParseDegraded {
+		t.Error("Expected recovery without alert")
+	}
+	if CheckParseHealth(&rule, degraded, config) == nil {
+		t.Error("Expected alert after degrading again")
+	}
+}
+
+func TestWebhookAlerter(t *testing.T) {
+	var payload webhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer server.Close()
+
+	err := NewWebhookAlerter(server.URL).SendAlerts(context.Background(), []Alert{{Rule: "a", Message: "broken"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Text != "a: broken" || len(payload.Alerts) != 1 {
+		t.Errorf("Unexpected payload: %+v", payload)
+	}
+}
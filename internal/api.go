@@ -0,0 +1,295 @@
+package reporter
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type ApiHandler struct {
+	rulesStore RulesStore
+	token      string
+	mux        *http.ServeMux
+}
+
+type apiError struct {
+	Error string
+}
+
+type ruleTestResult struct {
+	Listings    []Listing
+	NewListings []Listing
+	NewCutoffs  []string
+}
+
+func NewApiHandler(rulesStore RulesStore, token string) *ApiHandler {
+	h := &ApiHandler{rulesStore: rulesStore, token: token, mux: http.NewServeMux()}
+
+	h.mux.HandleFunc("GET /rules", h.listRules)
+	h.mux.HandleFunc("POST /rules", h.createRule)
+	h.mux.HandleFunc("GET /rules/{name}", h.getRule)
+	h.mux.HandleFunc("PUT /rules/{name}", h.updateRule)
+	h.mux.HandleFunc("DELETE /rules/{name}", h.deleteRule)
+	h.mux.HandleFunc("POST /rules/{name}/test", h.testRule)
+
+	return h
+}
+
+func NewHttpHandler(rulesStore RulesStore, apiToken string, links *SubscriptionLinks) http.Handler {
+	mux := http.NewServeMux()
+
+	api := NewApiHandler(rulesStore, apiToken)
+	mux.Handle("/rules", api)
+	mux.Handle("/rules/", api)
+	mux.Handle("/ui/", NewWebHandler(rulesStore, apiToken))
+
+	if links != nil {
+		subscriptions := NewSubscriptionHandler(links, rulesStore)
+		mux.Handle("/"+unsubscribeAction, subscriptions)
+		mux.Handle("/"+pauseAction, subscriptions)
+	}
+
+	return mux
+}
+
+func (h *ApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.isAuthorized(r) {
+		writeJson(w, http.StatusUnauthorized, apiError{Error: "unauthorized"})
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *ApiHandler) isAuthorized(r *http.Request) bool {
+	if h.token == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+func (h *ApiHandler) listRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.rulesStore.Get(r.Context())
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	writeJson(w, http.StatusOK, rules)
+}
+
+func (h *ApiHandler) getRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := h.findRule(w, r, r.PathValue("name"))
+	if !ok {
+		return
+	}
+	writeJson(w, http.StatusOK, rule)
+}
+
+func (h *ApiHandler) createRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := decodeRule(w, r)
+	if !ok {
+		return
+	}
+
+	existing, err := h.rulesStore.GetOne(r.Context(), rule.Name)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if existing != nil {
+		writeJson(w, http.StatusConflict, apiError{Error: fmt.Sprintf("rule %s already exists", rule.Name)})
+		return
+	}
+
+	rule.Version = 0
+	if rule.Cutoffs != nil {
+		rule.CutoffsUrl = SearchUrl(rule.Url, rule.Filters)
+	}
+	if !h.putDefinition(w, r, rule) {
+		return
+	}
+	h.writeStoredRule(w, r, rule.Name, http.StatusCreated)
+}
+
+func (h *ApiHandler) updateRule(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	rule, ok := decodeRule(w, r)
+	if !ok {
+		return
+	}
+	if rule.Name != name {
+		writeJson(w, http.StatusBadRequest, apiError{Error: "rule name does not match path"})
+		return
+	}
+
+	existing, ok := h.findRule(w, r, name)
+	if !ok {
+		return
+	}
+	// Updates must name the version they were based on, so they can't
+	// overwrite a change they haven't seen.
+	if rule.Version == 0 {
+		writeJson(w, http.StatusPreconditionRequired, apiError{Error: "rule version is required"})
+		return
+	}
+	// Both versions are checked before writing and the state is written last,
+	// so a conflict on the definition leaves the rule untouched.
+	if rule.Version != existing.Version {
+		writeJson(w, http.StatusConflict, apiError{Error: fmt.Sprintf("rule %s: %s", name, ErrVersionConflict)})
+		return
+	}
+	if !h.putDefinition(w, r, rule) {
+		return
+	}
+	if rule.Cutoffs != nil {
+		state := existing.State()
+		state.Cutoffs = rule.Cutoffs
+		state.CutoffsUrl = SearchUrl(rule.Url, rule.Filters)
+		err := h.rulesStore.PutState(r.Context(), name, state, existing.StateVersion)
+		if errors.Is(err, ErrVersionConflict) {
+			writeJson(w, http.StatusConflict, apiError{Error: fmt.Sprintf("rule %s was saved, but its state changed concurrently and cutoffs were not updated", name)})
+			return
+		}
+		if err != nil {
+			writeInternalError(w, err)
+			return
+		}
+	}
+	h.writeStoredRule(w, r, name, http.StatusOK)
+}
+
+func (h *ApiHandler) putDefinition(w http.ResponseWriter, r *http.Request, rule RetrievalRule) bool {
+	err := h.rulesStore.Put(r.Context(), rule)
+	if errors.Is(err, ErrVersionConflict) {
+		writeJson(w, http.StatusConflict, apiError{Error: err.Error()})
+		return false
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return false
+	}
+	return true
+}
+
+// writeStoredRule responds with the stored rule, whose version the client
+// sends back on the next update.
+func (h *ApiHandler) writeStoredRule(w http.ResponseWriter, r *http.Request, name string, status int) {
+	stored, ok := h.findRule(w, r, name)
+	if !ok {
+		return
+	}
+	writeJson(w, status, stored)
+}
+
+func (h *ApiHandler) deleteRule(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	_, ok := h.findRule(w, r, name)
+	if !ok {
+		return
+	}
+
+	err := h.rulesStore.Delete(r.Context(), name)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ApiHandler) testRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := h.findRule(w, r, r.PathValue("name"))
+	if !ok {
+		return
+	}
+
+	result, err := testRuleRun(r.Context(), defaultFetcher(), *rule)
+	if err != nil {
+		writeJson(w, http.StatusBadGateway, apiError{Error: err.Error()})
+		return
+	}
+	writeJson(w, http.StatusOK, result)
+}
+
+// testRuleRun fetches the rule's page and applies cutoffs and filters in the
+// same order as a run, so cutoffs are taken from the unfiltered page.
+func testRuleRun(ctx context.Context, source Source, rule RetrievalRule) (ruleTestResult, error) {
+	content, err := source.Fetch(ctx, SearchUrl(rule.Url, rule.Filters))
+	if err != nil {
+		return ruleTestResult{}, fmt.Errorf("site fetch failed: %w", err)
+	}
+	listings, _, err := Parse(content)
+	if err != nil {
+		return ruleTestResult{}, fmt.Errorf("site parse failed: %w", err)
+	}
+
+	result := ruleTestResult{
+		Listings:    FilterRule(listings, rule.Filters),
+		NewListings: []Listing{},
+		NewCutoffs:  GetNewCutoffs(listings),
+	}
+	if len(result.NewCutoffs) == 0 {
+		result.NewCutoffs = rule.Cutoffs
+	}
+	// A rule without cutoffs for this page only records them on its next run.
+	if cutoffs := rule.CurrentCutoffs(); len(cutoffs) > 0 {
+		result.NewListings = FilterRule(FilterCutoff(listings, cutoffs), rule.Filters)
+	}
+	return result, nil
+}
+
+func (h *ApiHandler) findRule(w http.ResponseWriter, r *http.Request, name string) (*RetrievalRule, bool) {
+	rule, err := h.rulesStore.GetOne(r.Context(), name)
+	if err != nil {
+		writeInternalError(w, err)
+		return nil, false
+	}
+	if rule == nil {
+		writeJson(w, http.StatusNotFound, apiError{Error: fmt.Sprintf("rule %s not found", name)})
+		return nil, false
+	}
+	return rule, true
+}
+
+func decodeRule(w http.ResponseWriter, r *http.Request) (RetrievalRule, bool) {
+	rule := RetrievalRule{}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&rule)
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, apiError{Error: fmt.Sprintf("invalid rule: %s", err)})
+		return rule, false
+	}
+
+	err = rule.Validate()
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return rule, false
+	}
+
+	return rule, true
+}
+
+func writeInternalError(w http.ResponseWriter, err error) {
+	slog.Error("api request failed", "error", err)
+	writeJson(w, http.StatusInternalServerError, apiError{Error: "internal error"})
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		slog.Error("api response encoding failed", "error", err)
+	}
+}
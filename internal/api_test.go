@@ -0,0 +1,181 @@
+package reporter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestApiHandlerUnauthorized(t *testing.T) {
+	handler := NewApiHandler(nil, "secret")
+
+	for _, auth := range []string{"", "Bearer wrong", "secret"} {
+		req := httptest.NewRequest(http.MethodGet, "/rules", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d for %q, got %d", http.StatusUnauthorized, auth, rec.Code)
+		}
+	}
+}
+
+func apiRequest(handler http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestApiHandlerUpdateRequiresVersion(t *testing.T) {
+	rules := &memRulesStore{}
+	err := rules.Put(context.Background(), RetrievalRule{Name: "flat", Email: "a@example.com", Url: "/a/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewApiHandler(rules, "secret")
+
+	cases := []struct {
+		body   string
+		status int
+	}{
+		{`{"Name": "flat", "Email": "b@example.com", "Url": "/b/"}`, http.StatusPreconditionRequired},
+		{`{"Name": "flat", "Email": "b@example.com", "Url": "/b/", "Version": 1}`, http.StatusOK},
+		{`{"Name": "flat", "Email": "c@example.com", "Url": "/c/", "Version": 1}`, http.StatusConflict},
+	}
+	for _, c := range cases {
+		rec := apiRequest(handler, http.MethodPut, "/rules/flat", c.body)
+		if rec.Code != c.status {
+			t.Errorf("Expected status %d for %s, got %d: %s", c.status, c.body, rec.Code, rec.Body)
+		}
+	}
+
+	rule, err := rules.GetOne(context.Background(), "flat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Email != "b@example.com" || rule.Version != 2 {
+		t.Errorf("Expected only the versioned update to be applied, got %+v", rule)
+	}
+}
+
+// racingRulesStore records a run's state right after each definition write.
+type racingRulesStore struct {
+	*memRulesStore
+}
+
+func (s racingRulesStore) Put(ctx context.Context, rule RetrievalRule) error {
+	err := s.memRulesStore.Put(ctx, rule)
+	if err != nil {
+		return err
+	}
+	stored, err := s.GetOne(ctx, rule.Name)
+	if err != nil {
+		return err
+	}
+	return s.PutState(ctx, rule.Name, RuleState{Cutoffs: []string{"run"}}, stored.StateVersion)
+}
+
+func TestApiHandlerUpdateCutoffsConflict(t *testing.T) {
+	rules := &memRulesStore{}
+	err := rules.Put(context.Background(), RetrievalRule{Name: "flat", Email: "a@example.com", Url: "/a/", Cutoffs: []string{"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := apiRequest(NewApiHandler(rules, "secret"), http.MethodPut, "/rules/flat",
+		`{"Name": "flat", "Email": "b@example.com", "Url": "/b/", "Cutoffs": ["2"], "Version": 7}`)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected conflict for stale version, got %d", rec.Code)
+	}
+	rule, err := rules.GetOne(context.Background(), "flat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Email != "a@example.com" || !slices.Equal(rule.Cutoffs, []string{"1"}) {
+		t.Errorf("Expected a rejected update to change nothing, got %+v", rule)
+	}
+
+	rec = apiRequest(NewApiHandler(racingRulesStore{rules}, "secret"), http.MethodPut, "/rules/flat",
+		`{"Name": "flat", "Email": "b@example.com", "Url": "/b/", "Cutoffs": ["2"], "Version": 1}`)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "was saved") {
+		t.Errorf("Expected conflict reporting the saved definition, got %d: %s", rec.Code, rec.Body)
+	}
+	rule, err = rules.GetOne(context.Background(), "flat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Email != "b@example.com" || !slices.Equal(rule.Cutoffs, []string{"run"}) {
+		t.Errorf("Expected the new definition with the run's cutoffs, got %+v", rule)
+	}
+}
+
+func TestApiHandlerUpdateCutoffsForNewUrl(t *testing.T) {
+	rules := &memRulesStore{}
+	err := rules.Put(context.Background(), RetrievalRule{Name: "flat", Email: "a@example.com", Url: "/a/", Cutoffs: []string{"1"}, CutoffsUrl: "/a/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewApiHandler(rules, "secret")
+
+	rec := apiRequest(handler, http.MethodPut, "/rules/flat",
+		`{"Name": "flat", "Email": "a@example.com", "Url": "/b/", "Cutoffs": ["2"], "Version": 1}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected update, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rule, err := rules.GetOne(context.Background(), "flat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(rule.CurrentCutoffs(), []string{"2"}) {
+		t.Errorf("Expected cutoffs set with the new url to be current, got %v from %s", rule.Cutoffs, rule.CutoffsUrl)
+	}
+}
+
+func TestTestRuleRunTakesCutoffsBeforeFilters(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "fixtures", "flats-riga-centre-sell.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	maxRooms := 2
+	rule := RetrievalRule{
+		Url:     "/lv/real-estate/flats/riga/centre/sell/",
+		Filters: Filters{Rooms: &RangeFilter[int]{To: &maxRooms}},
+		// The 4 room cutoff listing is removed by the rule's filters.
+		Cutoffs: []string{"53009650"},
+	}
+	rule.CutoffsUrl = SearchUrl(rule.Url, rule.Filters)
+	result, err := testRuleRun(context.Background(), &fakeSource{content: string(content)}, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(listings []Listing) []string {
+		ids := []string{}
+		for _, listing := range listings {
+			ids = append(ids, listing.Id)
+		}
+		return ids
+	}
+	if got := ids(result.Listings); !slices.Equal(got, []string{"53009874", "53008990"}) {
+		t.Errorf("Unexpected listings %v", got)
+	}
+	if got := ids(result.NewListings); !slices.Equal(got, []string{"53009874"}) {
+		t.Errorf("Expected only the filtered listing above the cutoff to be new, got %v", got)
+	}
+	if !slices.Equal(result.NewCutoffs, []string{"53010111", "53009874", "53009650"}) {
+		t.Errorf("Expected cutoffs from the unfiltered page, got %v", result.NewCutoffs)
+	}
+}
@@ -1,6 +1,7 @@
 package reporter
 
 import (
+	"context"
 	"fmt"
 	"io"
 
@@ -8,6 +9,10 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
+type CredentialsStore interface {
+	Get(ctx context.Context, name string) ([]byte, error)
+}
+
 type CredentialsBucket struct {
 	s3Svc      *s3.S3
 	bucketName string
@@ -20,8 +25,8 @@ func NewCredentialsBucket(awsSess *session.Session) *CredentialsBucket {
 	}
 }
 
-func (r *CredentialsBucket) Get(name string) ([]byte, error) {
-	res, err := r.s3Svc.GetObject(&s3.GetObjectInput{
+func (r *CredentialsBucket) Get(ctx context.Context, name string) ([]byte, error) {
+	res, err := r.s3Svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: &r.bucketName,
 		Key:    &name,
 	})
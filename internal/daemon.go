@@ -0,0 +1,199 @@
+package reporter
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	daemonTick           = 5 * time.Second
+	daemonReloadInterval = time.Minute
+)
+
+type Daemon struct {
+	reporter        *Reporter
+	defaultInterval time.Duration
+	metrics         *Metrics
+
+	mu         sync.Mutex
+	schedules  map[string]*ruleSchedule
+	lastReload time.Time
+	reloadErr  error
+	inFlight   sync.WaitGroup
+}
+
+type ruleSchedule struct {
+	Interval  time.Duration
+	NextRun   time.Time
+	LastRun   time.Time
+	LastError string
+	Running   bool
+}
+
+type daemonHealth struct {
+	Status     string
+	LastReload time.Time
+	Error      string `json:",omitempty"`
+	Rules      map[string]ruleSchedule
+}
+
+func NewDaemon(reporter *Reporter, defaultInterval time.Duration) *Daemon {
+	return &Daemon{
+		reporter:        reporter,
+		defaultInterval: defaultInterval,
+		metrics:         NewMetrics(),
+		schedules:       map[string]*ruleSchedule{},
+	}
+}
+
+func (d *Daemon) Run(ctx context.Context) {
+	ticker := time.NewTicker(daemonTick)
+	defer ticker.Stop()
+
+	d.tick(ctx, time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("shutting down, waiting for in-flight runs")
+			d.inFlight.Wait()
+			return
+		case now := <-ticker.C:
+			d.tick(ctx, now)
+		}
+	}
+}
+
+func (d *Daemon) tick(ctx context.Context, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.lastReload) >= daemonReloadInterval {
+		d.reload(ctx, now)
+	}
+
+	for name, schedule := range d.schedules {
+		if schedule.Running || now.Before(schedule.NextRun) {
+			continue
+		}
+		schedule.Running = true
+		d.inFlight.Add(1)
+		// In-flight runs are not cancelled on shutdown so they finish and record their cutoffs.
+		go d.runRule(context.WithoutCancel(ctx), name)
+	}
+}
+
+func (d *Daemon) reload(ctx context.Context, now time.Time) {
+	rules, err := d.reporter.Rules().Get(ctx)
+	d.lastReload = now
+	d.reloadErr = err
+	if err != nil {
+		slog.Error("rules reload failed", "error", err)
+		return
+	}
+
+	current := map[string]bool{}
+	for _, rule := range rules {
+		if !rule.IsActive(now) {
+			continue
+		}
+		current[rule.Name] = true
+
+		interval, err := rule.Interval(d.defaultInterval)
+		if err != nil {
+			slog.Warn("invalid check interval, using default", "rule", rule.Name, "interval", d.defaultInterval, "error", err)
+		}
+
+		schedule, ok := d.schedules[rule.Name]
+		if !ok {
+			nextRun := now
+			if rule.LastChecked != nil && rule.LastChecked.Add(interval).After(now) {
+				nextRun = rule.LastChecked.Add(interval)
+			}
+			d.schedules[rule.Name] = &ruleSchedule{
+				Interval: interval,
+				NextRun:  nextRun.Add(jitter(interval)),
+			}
+			continue
+		}
+		if schedule.Interval != interval {
+			schedule.Interval = interval
+			if !schedule.LastRun.IsZero() {
+				schedule.NextRun = schedule.LastRun.Add(interval + jitter(interval))
+			}
+		}
+	}
+
+	for name, schedule := range d.schedules {
+		if !current[name] && !schedule.Running {
+			delete(d.schedules, name)
+		}
+	}
+}
+
+func (d *Daemon) runRule(ctx context.Context, name string) {
+	defer d.inFlight.Done()
+
+	report, err := d.reporter.Run(ctx, RunOptions{Rules: []string{name}, DefaultInterval: d.defaultInterval})
+	d.metrics.Observe(report)
+	if err == nil {
+		err = report.Err()
+	}
+	if err != nil {
+		slog.Error("rule run failed", "rule", name, "run_id", report.RunId, "error", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	schedule, ok := d.schedules[name]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	schedule.Running = false
+	schedule.LastRun = now
+	schedule.NextRun = now.Add(schedule.Interval + jitter(schedule.Interval))
+	schedule.LastError = ""
+	if err != nil {
+		schedule.LastError = err.Error()
+	}
+}
+
+func (d *Daemon) Metrics() *Metrics {
+	return d.metrics
+}
+
+func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	health := daemonHealth{
+		Status:     "ok",
+		LastReload: d.lastReload,
+		Rules:      map[string]ruleSchedule{},
+	}
+	if d.reloadErr != nil {
+		health.Status = "error"
+		health.Error = d.reloadErr.Error()
+	}
+	for name, schedule := range d.schedules {
+		health.Rules[name] = *schedule
+	}
+	d.mu.Unlock()
+
+	status := http.StatusOK
+	if health.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJson(w, status, health)
+}
+
+func jitter(interval time.Duration) time.Duration {
+	max := int64(interval / 10)
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(max))
+}
@@ -0,0 +1,49 @@
+package reporter
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDaemonReload(t *testing.T) {
+	store := NewFileRulesStore(filepath.Join(t.TempDir(), "rules.json"))
+	for _, rule := range []RetrievalRule{
+		{Name: "hot", CheckInterval: "5m"},
+		{Name: "slow"},
+		{Name: "invalid", CheckInterval: "1s"},
+	} {
+		err := store.Put(context.Background(), rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	daemon := NewDaemon(NewReporter(ReporterConfig{Rules: store}), time.Hour)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	daemon.reload(context.Background(), now)
+
+	expected := map[string]time.Duration{"hot": 5 * time.Minute, "slow": time.Hour, "invalid": time.Hour}
+	for name, interval := range expected {
+		schedule, ok := daemon.schedules[name]
+		if !ok {
+			t.Fatalf("Expected schedule for %s", name)
+		}
+		if schedule.Interval != interval {
+			t.Errorf("Expected %s interval for %s, got %s", interval, name, schedule.Interval)
+		}
+		if schedule.NextRun.Before(now) || schedule.NextRun.After(now.Add(interval/10)) {
+			t.Errorf("Expected %s first run within jitter, got %s", name, schedule.NextRun)
+		}
+	}
+
+	err := store.Delete(context.Background(), "slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon.reload(context.Background(), now.Add(time.Minute))
+	if _, ok := daemon.schedules["slow"]; ok {
+		t.Error("Expected deleted rule to be unscheduled")
+	}
+}
@@ -0,0 +1,365 @@
+package reporter
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memRulesStore struct {
+	mu    sync.Mutex
+	rules []RetrievalRule
+}
+
+func (s *memRulesStore) Get(ctx context.Context) ([]RetrievalRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.rules), nil
+}
+
+func (s *memRulesStore) GetOne(ctx context.Context, name string) (*RetrievalRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rule := range s.rules {
+		if rule.Name == name {
+			return &rule, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *memRulesStore) Put(ctx context.Context, rule RetrievalRule) error {
+	return s.update(rule.Name, func(stored *RetrievalRule) (RetrievalRule, error) {
+		return mergeDefinition(stored, rule)
+	})
+}
+
+func (s *memRulesStore) PutState(ctx context.Context, name string, state RuleState, version int) error {
+	return s.update(name, func(stored *RetrievalRule) (RetrievalRule, error) {
+		return mergeState(stored, name, state, version)
+	})
+}
+
+func (s *memRulesStore) update(name string, apply func(stored *RetrievalRule) (RetrievalRule, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.rules, func(rule RetrievalRule) bool { return rule.Name == name })
+	var stored *RetrievalRule
+	if i >= 0 {
+		stored = &s.rules[i]
+	}
+	rule, err := apply(stored)
+	if err != nil {
+		return err
+	}
+	if i >= 0 {
+		s.rules[i] = rule
+	} else {
+		s.rules = append(s.rules, rule)
+	}
+	return nil
+}
+
+func (s *memRulesStore) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = slices.DeleteFunc(s.rules, func(rule RetrievalRule) bool { return rule.Name == name })
+	return nil
+}
+
+type memCredentialsStore map[string][]byte
+
+func (s memCredentialsStore) Get(ctx context.Context, name string) ([]byte, error) {
+	file, ok := s[name]
+	if !ok {
+		return nil, fmt.Errorf("credentials file %s not found", name)
+	}
+	return file, nil
+}
+
+// siteStandIn serves pages by path like ss.lv and records the filter forms
+// posted to them. It doesn't filter the page itself.
+type siteStandIn struct {
+	mu     sync.Mutex
+	pages  map[string]string
+	forms  []url.Values
+	status int
+}
+
+func (s *siteStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if strings.Contains(r.URL.RawQuery, "topt") {
+		http.Error(w, "filters must be posted", http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.forms = append(s.forms, r.PostForm)
+	}
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+	page, ok := s.pages[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write([]byte(page))
+}
+
+func (s *siteStandIn) serve(path string, page string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages[path] = page
+	s.status = status
+}
+
+type sentMessage struct {
+	To      string
+	Subject string
+}
+
+// gmailStandIn records messages posted to the Gmail send endpoint.
+type gmailStandIn struct {
+	mu     sync.Mutex
+	sent   []sentMessage
+	status int
+}
+
+func (g *gmailStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if r.URL.Path != "/gmail/v1/users/me/messages/send" || r.Header.Get("Authorization") != "Bearer test-token" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	if g.status != 0 {
+		w.WriteHeader(g.status)
+		return
+	}
+
+	body := struct{ Raw string }{}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	raw, err := base64.StdEncoding.DecodeString(body.Raw)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	g.sent = append(g.sent, sentMessage{To: msg.Header.Get("To"), Subject: subject})
+	w.Write([]byte(`{"id": "1"}`))
+}
+
+func (g *gmailStandIn) take() []sentMessage {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	sent := g.sent
+	g.sent = nil
+	return sent
+}
+
+func (g *gmailStandIn) fail(status int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.status = status
+}
+
+func newTestCredentials() memCredentialsStore {
+	return memCredentialsStore{
+		"credentials.json": []byte(`{"installed": {
+			"client_id": "client",
+			"client_secret": "secret",
+			"auth_uri": "http://127.0.0.1/auth",
+			"token_uri": "http://127.0.0.1/token",
+			"redirect_uris": ["http://localhost"]
+		}}`),
+		"token.json": []byte(`{"access_token": "test-token", "token_type": "Bearer", "expiry": "2100-01-01T00:00:00Z"}`),
+	}
+}
+
+var rowPattern = regexp.MustCompile(`(?s)<tr id="tr_(\d+)">.*?</tr>\n`)
+
+func withoutRows(page string, ids ...string) string {
+	return rowPattern.ReplaceAllStringFunc(page, func(row string) string {
+		if slices.Contains(ids, rowPattern.FindStringSubmatch(row)[1]) {
+			return ""
+		}
+		return row
+	})
+}
+
+func subjects(messages []sentMessage) []string {
+	subjects := []string{}
+	for _, msg := range messages {
+		subjects = append(subjects, msg.To+" "+msg.Subject)
+	}
+	return subjects
+}
+
+func TestEndToEnd(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "fixtures", "flats-riga-centre-sell.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fullPage := string(content)
+
+	const path = "/lv/real-estate/flats/riga/centre/sell/"
+	site := &siteStandIn{pages: map[string]string{}}
+	siteServer := httptest.NewServer(site)
+	defer siteServer.Close()
+
+	gmailServer := &gmailStandIn{}
+	gmailHttp := httptest.NewServer(gmailServer)
+	defer gmailHttp.Close()
+
+	ctx := context.Background()
+	emailClient, err := NewEmailClientFromStore(ctx, newTestCredentials(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emailClient.gmailSvc.BasePath = gmailHttp.URL + "/"
+
+	maxRooms := 2
+	rules := &memRulesStore{rules: []RetrievalRule{
+		{Name: "centre", Email: "all@example.com", Url: path},
+		{Name: "small", Email: "small@example.com", Url: path, Filters: Filters{Rooms: &RangeFilter[int]{To: &maxRooms}}},
+	}}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	reporter := NewReporter(ReporterConfig{
+		Rules:    rules,
+		Outbox:   NewFileOutbox(filepath.Join(t.TempDir(), "outbox.json")),
+		Notifier: emailClient,
+		Source:   newTestFetcher(siteServer.URL),
+		Now:      func() time.Time { return now },
+	})
+
+	scenarios := []struct {
+		name        string
+		page        string
+		siteStatus  int
+		gmailStatus int
+		failed      bool
+		sent        []string
+		cutoffs     []string
+	}{
+		{
+			name:    "first run only records cutoffs",
+			page:    withoutRows(fullPage, "53010111"),
+			sent:    []string{},
+			cutoffs: []string{"53009874", "53009650", "53008990"},
+		},
+		{
+			name:    "no change",
+			page:    withoutRows(fullPage, "53010111"),
+			sent:    []string{},
+			cutoffs: []string{"53009874", "53009650", "53008990"},
+		},
+		{
+			name:    "new listing",
+			page:    fullPage,
+			sent:    []string{"all@example.com Tērbatas 14"},
+			cutoffs: []string{"53010111", "53009874", "53009650"},
+		},
+		{
+			name:    "deleted cutoff",
+			page:    withoutRows(fullPage, "53010111", "53009874"),
+			sent:    []string{},
+			cutoffs: []string{"53009650", "53008990"},
+		},
+		{
+			name:       "site error keeps cutoffs",
+			siteStatus: http.StatusInternalServerError,
+			failed:     true,
+			sent:       []string{},
+			cutoffs:    []string{"53009650", "53008990"},
+		},
+		{
+			name:        "send error keeps notifications in outbox",
+			page:        fullPage,
+			gmailStatus: http.StatusInternalServerError,
+			failed:      true,
+			sent:        []string{},
+			cutoffs:     []string{"53010111", "53009874", "53009650"},
+		},
+		{
+			// Tērbatas 14 reappeared with the deleted cutoff, but the outbox
+			// already holds it as delivered to all@example.com.
+			name: "outbox delivered after recovery",
+			page: fullPage,
+			sent: []string{
+				"all@example.com Brīvības 102",
+				"small@example.com Brīvības 102",
+			},
+			cutoffs: []string{"53010111", "53009874", "53009650"},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		site.serve(path, scenario.page, scenario.siteStatus)
+		gmailServer.fail(scenario.gmailStatus)
+		now = now.Add(time.Hour)
+
+		report, err := reporter.Run(ctx, RunOptions{})
+		if err != nil {
+			t.Fatalf("%s: %s", scenario.name, err)
+		}
+		if failed := report.Err() != nil; failed != scenario.failed {
+			t.Errorf("%s: expected failed %t, got %v", scenario.name, scenario.failed, report.Err())
+		}
+
+		sent := subjects(gmailServer.take())
+		slices.Sort(sent)
+		slices.Sort(scenario.sent)
+		if !slices.Equal(sent, scenario.sent) {
+			t.Errorf("%s: expected sent %v, got %v", scenario.name, scenario.sent, sent)
+		}
+
+		for _, name := range []string{"centre", "small"} {
+			rule, err := rules.GetOne(ctx, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(rule.Cutoffs, scenario.cutoffs) {
+				t.Errorf("%s: expected %s cutoffs %v, got %v", scenario.name, name, scenario.cutoffs, rule.Cutoffs)
+			}
+		}
+	}
+
+	if len(site.forms) == 0 || site.forms[0].Get("topt[1][max]") != "2" {
+		t.Errorf("Expected the rooms filter to be posted to the site, got %v", site.forms)
+	}
+}
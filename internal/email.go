@@ -2,10 +2,15 @@ package reporter
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"html"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/gmail/v1"
@@ -14,14 +19,16 @@ import (
 
 type EmailClient struct {
 	gmailSvc *gmail.Service
+	links    *SubscriptionLinks
 }
 
 type Email struct {
 	To      string
+	Rule    string
 	Listing Listing
 }
 
-func NewEmailClient(configFile []byte, tokenFile []byte) (*EmailClient, error) {
+func NewEmailClient(ctx context.Context, configFile []byte, tokenFile []byte, links *SubscriptionLinks) (*EmailClient, error) {
 	config, err := google.ConfigFromJSON(configFile, gmail.GmailSendScope)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create config from credentials: %w", err)
@@ -33,8 +40,6 @@ func NewEmailClient(configFile []byte, tokenFile []byte) (*EmailClient, error) {
 		return nil, fmt.Errorf("failed to unmarshal token: %w", err)
 	}
 
-	ctx := context.Background()
-
 	client := config.Client(ctx, token)
 
 	svc, err := gmail.NewService(ctx, option.WithHTTPClient(client))
@@ -42,10 +47,48 @@ func NewEmailClient(configFile []byte, tokenFile []byte) (*EmailClient, error) {
 		return nil, fmt.Errorf("failed to create gmail service: %w", err)
 	}
 
-	return &EmailClient{gmailSvc: svc}, nil
+	return &EmailClient{gmailSvc: svc, links: links}, nil
+}
+
+func NewEmailClientFromStore(ctx context.Context, credentialsStore CredentialsStore, links *SubscriptionLinks) (*EmailClient, error) {
+	configFile, err := getCredentialsFile(ctx, credentialsStore, "credentials.json")
+	if err != nil {
+		return nil, err
+	}
+	tokenFile, err := getCredentialsFile(ctx, credentialsStore, "token.json")
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewEmailClient(ctx, configFile, tokenFile, links)
+	if err != nil {
+		return nil, fmt.Errorf("email client creation failed: %w", err)
+	}
+	return client, nil
+}
+
+func getCredentialsFile(ctx context.Context, credentialsStore CredentialsStore, name string) ([]byte, error) {
+	_, span := tracer.Start(ctx, "credentials.get", trace.WithAttributes(attribute.String("credentials.name", name)))
+	file, err := credentialsStore.Get(ctx, name)
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("%s retrieval for email failed: %w", name, err)
+	}
+	return file, nil
+}
+
+type RenderedEmail struct {
+	To        string
+	Subject   string
+	MessageId string
+	Headers   string
+	Body      string
 }
 
-func (e *EmailClient) SendListing(email Email) error {
+func (e *EmailClient) SendListing(ctx context.Context, email Email) error {
+	return e.send(ctx, RenderListingEmail(email, e.links))
+}
+
+func RenderListingEmail(email Email, links *SubscriptionLinks) RenderedEmail {
 	listing := email.Listing
 
 	rows := []map[string]string{
@@ -70,23 +113,52 @@ func (e *EmailClient) SendListing(email Email) error {
 
 	body := fmt.Sprintf("<table border=\"1\" cellpadding=\"10\" cellspacing=\"0\">%s</table>", tableBody)
 
-	return e.send(email.To, listing.Street, body)
+	headers := ""
+	if links != nil {
+		unsubscribeUrl := links.UnsubscribeUrl(email.Rule, email.To)
+		pauseUrl := links.PauseUrl(email.Rule, email.To)
+
+		body += fmt.Sprintf(
+			"<p><a href=\"%s\">Pause for 7 days</a> | <a href=\"%s\">Unsubscribe</a></p>",
+			html.EscapeString(pauseUrl),
+			html.EscapeString(unsubscribeUrl),
+		)
+		headers = "List-Unsubscribe: <" + unsubscribeUrl + ">\r\n" +
+			"List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n"
+	}
+
+	return RenderedEmail{To: email.To, Subject: listing.Street, MessageId: listingMessageId(email), Headers: headers, Body: body}
+}
+
+// listingMessageId is the same on every send of a notification, so a resend
+// after a send with an unknown outcome can be recognised as a copy.
+func listingMessageId(email Email) string {
+	sum := sha256.Sum256([]byte(OutboxKey(email)))
+	return "<" + hex.EncodeToString(sum[:16]) + "@listing-reporter>"
 }
 
-func (e *EmailClient) send(to string, subject string, body string) error {
+func (r RenderedEmail) Raw() string {
 	from := "me"
-	encodedSubject := "=?UTF-8?B?" + base64.StdEncoding.EncodeToString([]byte(subject)) + "?="
+	encodedSubject := "=?UTF-8?B?" + base64.StdEncoding.EncodeToString([]byte(r.Subject)) + "?="
+	messageIdHeader := ""
+	if r.MessageId != "" {
+		messageIdHeader = "Message-ID: " + r.MessageId + "\r\n"
+	}
+	return "From: " + from + "\r\n" +
+		"To: " + r.To + "\r\n" +
+		"Subject: " + encodedSubject + "\r\n" +
+		messageIdHeader +
+		r.Headers +
+		"Content-Type: text/html; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" + r.Body
+}
+
+func (e *EmailClient) send(ctx context.Context, email RenderedEmail) error {
 	msg := gmail.Message{
-		Raw: base64.StdEncoding.EncodeToString([]byte(
-			"From: " + from + "\r\n" +
-				"To: " + to + "\r\n" +
-				"Subject: " + encodedSubject + "\r\n" +
-				"Content-Type: text/html; charset=UTF-8\r\n" +
-				"Content-Transfer-Encoding: 8bit\r\n" +
-				"\r\n" + body,
-		)),
+		Raw: base64.StdEncoding.EncodeToString([]byte(email.Raw())),
 	}
 
-	_, err := e.gmailSvc.Users.Messages.Send("me", &msg).Do()
+	_, err := e.gmailSvc.Users.Messages.Send("me", &msg).Context(ctx).Do()
 	return err
 }
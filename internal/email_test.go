@@ -0,0 +1,39 @@
+package reporter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderListingEmail(t *testing.T) {
+	email := Email{
+		To:      "user@example.com",
+		Rule:    "flat",
+		Listing: Listing{Id: "1", Url: "https://www.ss.lv/msg/1.html", Street: "Brivibas 1"},
+	}
+
+	plain := RenderListingEmail(email, nil)
+	if plain.Headers != "" {
+		t.Errorf("Expected no extra headers without links, got %q", plain.Headers)
+	}
+	if !strings.Contains(plain.Body, email.Listing.Url) {
+		t.Error("Expected body to contain listing url")
+	}
+	if !strings.Contains(plain.Raw(), "Message-ID: "+RenderListingEmail(email, nil).MessageId+"\r\n") {
+		t.Error("Expected Message-ID header")
+	}
+	other := email
+	other.Listing.Id = "2"
+	if RenderListingEmail(other, nil).MessageId == plain.MessageId {
+		t.Error("Expected another listing to get another Message-ID")
+	}
+
+	links := NewSubscriptionLinks("https://example.com", []byte("secret"))
+	withLinks := RenderListingEmail(email, links)
+	if !strings.Contains(withLinks.Raw(), "List-Unsubscribe: <"+links.UnsubscribeUrl("flat", "user@example.com")+">\r\n") {
+		t.Error("Expected List-Unsubscribe header")
+	}
+	if !strings.Contains(withLinks.Body, "Unsubscribe</a>") {
+		t.Error("Expected unsubscribe link in body")
+	}
+}
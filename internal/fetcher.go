@@ -0,0 +1,326 @@
+package reporter
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type FetcherConfig struct {
+	BaseUrl        string
+	UserAgent      string
+	Timeout        time.Duration
+	MaxRetries     int
+	BaseBackoff    time.Duration
+	MaxBackoff     time.Duration
+	MaxConcurrency int
+	MinInterval    time.Duration
+}
+
+type Fetcher struct {
+	config FetcherConfig
+	client *http.Client
+
+	mu    sync.Mutex
+	hosts map[string]*hostLimiter
+	cache map[string]cachedPage
+}
+
+type StatusError struct {
+	Url  string
+	Code int
+}
+
+type hostLimiter struct {
+	slots       chan struct{}
+	mu          sync.Mutex
+	lastRequest time.Time
+}
+
+type cachedPage struct {
+	etag         string
+	lastModified string
+	body         string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d for %s", e.Code, e.Url)
+}
+
+func DefaultFetcherConfig() FetcherConfig {
+	return FetcherConfig{
+		BaseUrl:        baseUrl,
+		UserAgent:      "listing-reporter (+https://github.com/niklc/listing-reporter)",
+		Timeout:        10 * time.Second,
+		MaxRetries:     3,
+		BaseBackoff:    500 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		MaxConcurrency: 2,
+		MinInterval:    250 * time.Millisecond,
+	}
+}
+
+func FetcherConfigFromEnv() (FetcherConfig, error) {
+	config := DefaultFetcherConfig()
+	config.UserAgent = cmp.Or(os.Getenv("USER_AGENT"), config.UserAgent)
+
+	var err error
+	if config.Timeout, err = getDurationEnv("FETCH_TIMEOUT", config.Timeout); err != nil {
+		return config, err
+	}
+	if config.MinInterval, err = getDurationEnv("FETCH_MIN_INTERVAL", config.MinInterval); err != nil {
+		return config, err
+	}
+	if config.MaxRetries, err = getIntEnv("FETCH_MAX_RETRIES", config.MaxRetries); err != nil {
+		return config, err
+	}
+	if config.MaxConcurrency, err = getIntEnv("FETCH_CONCURRENCY", config.MaxConcurrency); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+func NewFetcher(config FetcherConfig) *Fetcher {
+	return &Fetcher{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		hosts:  map[string]*hostLimiter{},
+		cache:  map[string]cachedPage{},
+	}
+}
+
+var defaultFetcher = sync.OnceValue(func() *Fetcher {
+	config, err := FetcherConfigFromEnv()
+	if err != nil {
+		slog.Warn("invalid fetcher config, using defaults", "error", err)
+		config = DefaultFetcherConfig()
+	}
+	return NewFetcher(config)
+})
+
+func Fetch(ctx context.Context, path string) (string, error) {
+	return defaultFetcher().Fetch(ctx, path)
+}
+
+func (f *Fetcher) Fetch(ctx context.Context, path string) (string, error) {
+	target := f.config.BaseUrl + path
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var body string
+		var retryAfter time.Duration
+		body, retryAfter, err = f.fetchOnce(ctx, target)
+		if err == nil {
+			return body, nil
+		}
+		if attempt >= f.config.MaxRetries || !isRetryable(err) || ctx.Err() != nil {
+			break
+		}
+
+		wait := max(f.backoff(attempt), min(retryAfter, f.config.MaxBackoff))
+		slog.Warn("fetch failed, retrying", "stage", fetchStage, "url", target, "wait", wait, "error", err)
+		if sleepErr := sleepContext(ctx, wait); sleepErr != nil {
+			break
+		}
+	}
+
+	return "", fmt.Errorf("fetch %s failed: %w", target, err)
+}
+
+func (f *Fetcher) fetchOnce(ctx context.Context, target string) (string, time.Duration, error) {
+	req, err := newSearchRequest(ctx, target)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("User-Agent", f.config.UserAgent)
+
+	// Only plain page loads are revalidated, a filtered search is posted.
+	var cached cachedPage
+	isCached := false
+	if req.Method == http.MethodGet {
+		f.mu.Lock()
+		cached, isCached = f.cache[target]
+		f.mu.Unlock()
+	}
+	if isCached {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	limiter := f.hostLimiter(req.URL.Host)
+	err = limiter.acquire(ctx, f.config.MinInterval)
+	if err != nil {
+		return "", 0, err
+	}
+	res, err := f.client.Do(req)
+	limiter.release()
+	if err != nil {
+		return "", 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified && isCached {
+		return cached.body, 0, nil
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		io.Copy(io.Discard, res.Body)
+		return "", parseRetryAfter(res.Header.Get("Retry-After")), &StatusError{Url: target, Code: res.StatusCode}
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", 0, err
+	}
+
+	etag := res.Header.Get("ETag")
+	lastModified := res.Header.Get("Last-Modified")
+	if req.Method == http.MethodGet && (etag != "" || lastModified != "") {
+		f.mu.Lock()
+		f.cache[target] = cachedPage{etag: etag, lastModified: lastModified, body: string(body)}
+		f.mu.Unlock()
+	}
+
+	return string(body), 0, nil
+}
+
+// newSearchRequest loads target, posting its search filters like ss.lv's
+// filter form does.
+func newSearchRequest(ctx context.Context, target string) (*http.Request, error) {
+	pageUrl, form, err := searchForm(target)
+	if err != nil {
+		return nil, err
+	}
+	if len(form) == 0 {
+		return http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pageUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+func (f *Fetcher) hostLimiter(host string) *hostLimiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	limiter, ok := f.hosts[host]
+	if !ok {
+		limiter = &hostLimiter{slots: make(chan struct{}, max(1, f.config.MaxConcurrency))}
+		f.hosts[host] = limiter
+	}
+	return limiter
+}
+
+func (f *Fetcher) backoff(attempt int) time.Duration {
+	backoff := f.config.BaseBackoff << attempt
+	if backoff <= 0 || backoff > f.config.MaxBackoff {
+		backoff = f.config.MaxBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int64N(int64(backoff/2)+1))
+}
+
+func (l *hostLimiter) acquire(ctx context.Context, minInterval time.Duration) error {
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := sleepContext(ctx, time.Until(l.lastRequest.Add(minInterval)))
+	if err != nil {
+		l.release()
+		return err
+	}
+	l.lastRequest = time.Now()
+	return nil
+}
+
+func (l *hostLimiter) release() {
+	<-l.slots
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func isRetryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code == http.StatusTooManyRequests || statusErr.Code >= 500
+	}
+	// Only transient network errors are retried. Errors like a bad
+	// certificate or an invalid url would fail the same way again.
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func parseRetryAfter(val string) time.Duration {
+	if val == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(val); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(val); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+func getDurationEnv(name string, defaultVal time.Duration) (time.Duration, error) {
+	val := os.Getenv(name)
+	if val == "" {
+		return defaultVal, nil
+	}
+	duration, err := time.ParseDuration(val)
+	if err != nil {
+		return defaultVal, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return duration, nil
+}
+
+func getIntEnv(name string, defaultVal int) (int, error) {
+	val := os.Getenv(name)
+	if val == "" {
+		return defaultVal, nil
+	}
+	num, err := strconv.Atoi(val)
+	if err != nil {
+		return defaultVal, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return num, nil
+}
@@ -0,0 +1,149 @@
+package reporter
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newTestFetcher(url string) *Fetcher {
+	config := DefaultFetcherConfig()
+	config.BaseUrl = url
+	config.BaseBackoff = time.Millisecond
+	config.MaxBackoff = time.Millisecond
+	config.MinInterval = 0
+	return NewFetcher(config)
+}
+
+func TestFetcherRetries(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("User-Agent") == "" {
+			t.Error("Expected User-Agent header")
+		}
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	body, err := newTestFetcher(server.URL).Fetch(context.Background(), "/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "ok" || requests != 3 {
+		t.Errorf("Expected body after 3 requests, got %q after %d", body, requests)
+	}
+}
+
+func TestFetcherStatusError(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	_, err := newTestFetcher(server.URL).Fetch(context.Background(), "/page")
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusNotFound {
+		t.Errorf("Expected not found status error, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected no retries for not found, got %d requests", requests)
+	}
+}
+
+func TestFetcherDoesNotRetryTLSError(t *testing.T) {
+	var conns atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	_, err := newTestFetcher(server.URL).Fetch(context.Background(), "/page")
+
+	var certErr *tls.CertificateVerificationError
+	if !errors.As(err, &certErr) {
+		t.Errorf("Expected certificate error, got %v", err)
+	}
+	if conns.Load() != 1 {
+		t.Errorf("Expected no retries for a certificate error, got %d connections", conns.Load())
+	}
+}
+
+func TestFetcherRetriesRefusedConnection(t *testing.T) {
+	if !isRetryable(fmt.Errorf("dial: %w", syscall.ECONNREFUSED)) {
+		t.Error("Expected refused connection to be retried")
+	}
+	if isRetryable(errors.New("unsupported protocol scheme")) {
+		t.Error("Expected other errors not to be retried")
+	}
+}
+
+func TestFetcherPostsSearchFilters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.RawQuery != "" {
+			t.Errorf("Expected filters posted without a query, got %s %s", r.Method, r.URL)
+		}
+		if r.URL.Path != "/lv/real-estate/flats/riga/centre/sell/" || r.PostFormValue("topt[8][max]") != "90000" {
+			t.Errorf("Expected price filter posted to the page, got %s %v", r.URL.Path, r.PostForm)
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	priceTo := 90000.0
+	path := SearchUrl("/lv/real-estate/flats/riga/centre/sell/", Filters{Price: &RangeFilter[float64]{To: &priceTo}})
+	body, err := newTestFetcher(server.URL).Fetch(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "ok" {
+		t.Errorf("Expected body, got %q", body)
+	}
+}
+
+func TestFetcherConditionalRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("page"))
+	}))
+	defer server.Close()
+
+	fetcher := newTestFetcher(server.URL)
+	for i := 0; i < 2; i++ {
+		body, err := fetcher.Fetch(context.Background(), "/page")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != "page" {
+			t.Errorf("Request %d: expected cached body, got %q", i, body)
+		}
+	}
+}
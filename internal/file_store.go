@@ -0,0 +1,354 @@
+package reporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type FileRulesStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileRulesStore(path string) *FileRulesStore {
+	return &FileRulesStore{path: path}
+}
+
+func (r *FileRulesStore) Get(ctx context.Context) ([]RetrievalRule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.read()
+}
+
+func (r *FileRulesStore) GetOne(ctx context.Context, name string) (*RetrievalRule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rules, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if rule.Name == name {
+			return &rule, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *FileRulesStore) Put(ctx context.Context, rule RetrievalRule) error {
+	return r.update(rule.Name, func(stored *RetrievalRule) (RetrievalRule, error) {
+		return mergeDefinition(stored, rule)
+	})
+}
+
+func (r *FileRulesStore) PutState(ctx context.Context, name string, state RuleState, version int) error {
+	return r.update(name, func(stored *RetrievalRule) (RetrievalRule, error) {
+		return mergeState(stored, name, state, version)
+	})
+}
+
+func (r *FileRulesStore) update(name string, apply func(stored *RetrievalRule) (RetrievalRule, error)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, err := r.read()
+	if err != nil {
+		return err
+	}
+
+	byName := map[string]RetrievalRule{}
+	for _, rule := range existing {
+		byName[rule.Name] = rule
+	}
+	var stored *RetrievalRule
+	if rule, ok := byName[name]; ok {
+		stored = &rule
+	}
+	rule, err := apply(stored)
+	if err != nil {
+		return err
+	}
+	byName[name] = rule
+
+	return r.write(byName)
+}
+
+func (r *FileRulesStore) Delete(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, err := r.read()
+	if err != nil {
+		return err
+	}
+
+	byName := map[string]RetrievalRule{}
+	for _, rule := range existing {
+		byName[rule.Name] = rule
+	}
+	delete(byName, name)
+
+	return r.write(byName)
+}
+
+func (r *FileRulesStore) read() ([]RetrievalRule, error) {
+	content, err := os.ReadFile(r.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []RetrievalRule{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file %s: %w", r.path, err)
+	}
+
+	rules := []RetrievalRule{}
+	if r.isYaml() {
+		err = unmarshalYaml(content, &rules)
+	} else {
+		err = json.Unmarshal(content, &rules)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rules file %s: %w", r.path, err)
+	}
+	return rules, nil
+}
+
+func (r *FileRulesStore) write(byName map[string]RetrievalRule) error {
+	rules := make([]RetrievalRule, 0, len(byName))
+	for _, rule := range byName {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+
+	var content []byte
+	var err error
+	if r.isYaml() {
+		content, err = marshalYaml(rules)
+	} else {
+		content, err = json.MarshalIndent(rules, "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode rules: %w", err)
+	}
+
+	tmp := r.path + ".tmp"
+	err = os.WriteFile(tmp, content, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write rules file %s: %w", r.path, err)
+	}
+	return os.Rename(tmp, r.path)
+}
+
+func (r *FileRulesStore) isYaml() bool {
+	ext := strings.ToLower(filepath.Ext(r.path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+type FileOutbox struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileOutbox(path string) *FileOutbox {
+	return &FileOutbox{path: path}
+}
+
+func (o *FileOutbox) Enqueue(ctx context.Context, items []OutboxItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	existing, err := o.read()
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if _, ok := existing[item.Key]; !ok {
+			existing[item.Key] = item
+		}
+	}
+	return o.write(existing)
+}
+
+func (o *FileOutbox) Pending(ctx context.Context, rules []string) ([]OutboxItem, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	existing, err := o.read()
+	if err != nil {
+		return nil, err
+	}
+
+	include := map[string]bool{}
+	for _, rule := range rules {
+		include[rule] = true
+	}
+
+	items := []OutboxItem{}
+	for _, item := range existing {
+		if item.isPending() && include[item.Email.Rule] {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedAt.Before(items[j].CreatedAt) })
+	return items, nil
+}
+
+func (o *FileOutbox) Claim(ctx context.Context, key string, owner string, until time.Time) error {
+	return o.update(key, func(item *OutboxItem) error {
+		if !item.isPending() || item.isClaimed(time.Now()) {
+			return fmt.Errorf("notification %s: %w", key, ErrNotificationClaimed)
+		}
+		item.ClaimedBy = owner
+		item.ClaimedUntil = until.Unix()
+		return nil
+	})
+}
+
+func (o *FileOutbox) MarkDelivered(ctx context.Context, key string) error {
+	return o.update(key, func(item *OutboxItem) error {
+		now := time.Now()
+		item.DeliveredAt = &now
+		item.ExpiresAt = now.Add(outboxRetentionTime).Unix()
+		item.ClaimedBy, item.ClaimedUntil = "", 0
+		return nil
+	})
+}
+
+func (o *FileOutbox) MarkFailed(ctx context.Context, key string, reason string) error {
+	return o.update(key, func(item *OutboxItem) error {
+		item.Attempts++
+		item.LastError = reason
+		item.ClaimedBy, item.ClaimedUntil = "", 0
+		return nil
+	})
+}
+
+func (o *FileOutbox) MarkDead(ctx context.Context, key string, reason string) error {
+	return o.update(key, func(item *OutboxItem) error {
+		now := time.Now()
+		item.Attempts++
+		item.LastError = reason
+		item.DeadAt = &now
+		item.ExpiresAt = now.Add(outboxRetentionTime).Unix()
+		item.ClaimedBy, item.ClaimedUntil = "", 0
+		return nil
+	})
+}
+
+func (o *FileOutbox) update(key string, fn func(item *OutboxItem) error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	existing, err := o.read()
+	if err != nil {
+		return err
+	}
+	item, ok := existing[key]
+	if !ok {
+		return fmt.Errorf("notification %s not found", key)
+	}
+	err = fn(&item)
+	if err != nil {
+		return err
+	}
+	existing[key] = item
+	return o.write(existing)
+}
+
+func (o *FileOutbox) read() (map[string]OutboxItem, error) {
+	items := map[string]OutboxItem{}
+	content, err := os.ReadFile(o.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return items, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox file %s: %w", o.path, err)
+	}
+	err = json.Unmarshal(content, &items)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse outbox file %s: %w", o.path, err)
+	}
+	return items, nil
+}
+
+func (o *FileOutbox) write(items map[string]OutboxItem) error {
+	now := time.Now().Unix()
+	for key, item := range items {
+		if item.ExpiresAt != 0 && item.ExpiresAt < now {
+			delete(items, key)
+		}
+	}
+
+	content, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox: %w", err)
+	}
+	tmp := o.path + ".tmp"
+	err = os.WriteFile(tmp, content, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write outbox file %s: %w", o.path, err)
+	}
+	return os.Rename(tmp, o.path)
+}
+
+type CredentialsDir struct {
+	dir string
+}
+
+func NewCredentialsDir(dir string) *CredentialsDir {
+	return &CredentialsDir{dir: dir}
+}
+
+func (c *CredentialsDir) Get(ctx context.Context, name string) ([]byte, error) {
+	file, err := os.ReadFile(filepath.Join(c.dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %w", name, err)
+	}
+	return file, nil
+}
+
+// YAML goes through JSON so field names match the JSON and DynamoDB representation.
+func unmarshalYaml(content []byte, target any) error {
+	var doc any
+	err := yaml.Unmarshal(content, &doc)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		return nil
+	}
+	asJson, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(asJson, target)
+}
+
+func marshalYaml(source any) ([]byte, error) {
+	asJson, err := json.Marshal(source)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	err = json.Unmarshal(asJson, &doc)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
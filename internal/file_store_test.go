@@ -0,0 +1,123 @@
+package reporter
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFileRulesStore(t *testing.T) {
+	for _, name := range []string{"rules.json", "rules.yaml"} {
+		store := NewFileRulesStore(filepath.Join(t.TempDir(), name))
+
+		rules, err := store.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rules) != 0 {
+			t.Errorf("%s: expected no rules in missing file, got %d", name, len(rules))
+		}
+
+		roomsFrom := 2
+		for _, rule := range []RetrievalRule{
+			{Name: "a", Email: "a@example.com", Url: "/a/", Filters: Filters{Rooms: &RangeFilter[int]{From: &roomsFrom}}},
+			{Name: "b", Email: "b@example.com", Url: "/b/"},
+		} {
+			err = store.Put(context.Background(), rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = store.PutState(context.Background(), "b", RuleState{Cutoffs: []string{"1"}}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Delete(context.Background(), "missing")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rule, err := store.GetOne(context.Background(), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rule == nil || rule.Filters.Rooms == nil || *rule.Filters.Rooms.From != roomsFrom {
+			t.Errorf("%s: unexpected rule %+v", name, rule)
+		}
+
+		rule, err = store.GetOne(context.Background(), "b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rule == nil || len(rule.Cutoffs) != 1 {
+			t.Errorf("%s: expected updated cutoffs, got %+v", name, rule)
+		}
+
+		err = store.Delete(context.Background(), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules, err = store.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rules) != 1 || rules[0].Name != "b" {
+			t.Errorf("%s: expected only rule b, got %+v", name, rules)
+		}
+	}
+}
+
+func TestFileRulesStoreVersions(t *testing.T) {
+	ctx := context.Background()
+	store := NewFileRulesStore(filepath.Join(t.TempDir(), "rules.json"))
+
+	err := store.Put(ctx, RetrievalRule{Name: "a", Email: "a@example.com", Url: "/a/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := store.GetOne(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edited := *read
+	edited.Url = "/edited/"
+	err = store.Put(ctx, edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Put(ctx, edited)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected conflict for stale definition, got %v", err)
+	}
+	err = store.Put(ctx, RetrievalRule{Name: "a", Email: "a@example.com", Url: "/a/"})
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected conflict when creating an existing rule, got %v", err)
+	}
+
+	err = store.PutState(ctx, "a", RuleState{Cutoffs: []string{"1"}}, read.StateVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.PutState(ctx, "a", RuleState{Cutoffs: []string{"2"}}, read.StateVersion)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected conflict for stale state, got %v", err)
+	}
+	err = store.PutState(ctx, "missing", RuleState{}, 0)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected conflict for missing rule, got %v", err)
+	}
+
+	rule, err := store.GetOne(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Url != "/edited/" || !slices.Equal(rule.Cutoffs, []string{"1"}) {
+		t.Errorf("Expected edited definition with first state, got %+v", rule)
+	}
+	if rule.Version != 2 || rule.StateVersion != 1 {
+		t.Errorf("Expected version 2 and state version 1, got %d and %d", rule.Version, rule.StateVersion)
+	}
+}
@@ -0,0 +1,68 @@
+package reporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const FixturesDir = "internal/testdata/fixtures"
+
+type parseGolden struct {
+	Stats    ParseStats
+	Listings []Listing
+}
+
+func RenderGolden(content string) ([]byte, error) {
+	listings, stats, err := Parse(content)
+	if err != nil {
+		return nil, err
+	}
+	golden, err := json.MarshalIndent(parseGolden{Stats: stats, Listings: listings}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(golden, '\n'), nil
+}
+
+func FixtureName(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	return fileSafeName(strings.Trim(path, "/"))
+}
+
+func CaptureFixture(ctx context.Context, rawUrl string, dir string, name string) (string, error) {
+	path, err := PathFromUrl(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		name = FixtureName(path)
+	}
+
+	content, err := Fetch(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	golden, err := RenderGolden(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse captured page: %w", err)
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create fixtures dir: %w", err)
+	}
+	base := filepath.Join(dir, name)
+	err = os.WriteFile(base+".html", []byte(content), 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to write fixture: %w", err)
+	}
+	err = os.WriteFile(base+".golden.json", golden, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to write golden file: %w", err)
+	}
+	return base, nil
+}
@@ -0,0 +1,85 @@
+package reporter
+
+import (
+	"bytes"
+	"flag"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "regenerate golden files of parser fixtures")
+
+func TestParseFixtures(t *testing.T) {
+	pages, err := filepath.Glob("testdata/fixtures/*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) == 0 {
+		t.Fatal("Expected fixtures in testdata/fixtures")
+	}
+
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".html")
+		t.Run(name, func(t *testing.T) {
+			content, err := os.ReadFile(page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := RenderGolden(string(content))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			goldenPath := strings.TrimSuffix(page, ".html") + ".golden.json"
+			if *update {
+				err = os.WriteFile(goldenPath, got, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, expected) {
+				t.Errorf("Parse output differs from %s, rerun with -update to accept:\n%s", goldenPath, got)
+			}
+		})
+	}
+}
+
+// fixtureCategories are the page kinds the fixtures should cover, matched by
+// fixture file name.
+var fixtureCategories = map[string]string{
+	"flats for sale": "*flats*sell*.html",
+	"flats for rent": "*flats*hand-over*.html",
+	"houses":         "*houses*.html",
+	"land":           "*plots*.html",
+}
+
+func TestFixtureCoverage(t *testing.T) {
+	missing := []string{}
+	for _, category := range slices.Sorted(maps.Keys(fixtureCategories)) {
+		matches, err := filepath.Glob(filepath.Join("testdata", "fixtures", fixtureCategories[category]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) == 0 {
+			missing = append(missing, category)
+		}
+	}
+	if len(missing) > 0 {
+		t.Skipf("No captured fixtures for %s, add them with capture-fixture", strings.Join(missing, ", "))
+	}
+}
+
+func TestFixtureName(t *testing.T) {
+	name := FixtureName("/lv/real-estate/flats/riga/centre/sell/?topt[8][min]=1000")
+	if name != "lv_real-estate_flats_riga_centre_sell" {
+		t.Errorf("Unexpected fixture name: %s", name)
+	}
+}
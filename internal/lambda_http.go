@@ -0,0 +1,83 @@
+package reporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type LambdaHttpFunc func(context.Context, events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error)
+
+func NewLambdaHttpFunc(handler http.Handler) LambdaHttpFunc {
+	return func(ctx context.Context, event events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+		req, err := newLambdaHttpRequest(ctx, event)
+		if err != nil {
+			return events.LambdaFunctionURLResponse{}, err
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		res := events.LambdaFunctionURLResponse{
+			StatusCode: rec.Code,
+			Headers:    map[string]string{},
+		}
+		for k, v := range rec.Header() {
+			// Set-Cookie values can contain commas, so they are returned
+			// separately instead of joined.
+			if k == "Set-Cookie" {
+				res.Cookies = v
+				continue
+			}
+			res.Headers[k] = strings.Join(v, ",")
+		}
+
+		body := rec.Body.Bytes()
+		if utf8.Valid(body) {
+			res.Body = string(body)
+		} else {
+			res.Body = base64.StdEncoding.EncodeToString(body)
+			res.IsBase64Encoded = true
+		}
+
+		return res, nil
+	}
+}
+
+func newLambdaHttpRequest(ctx context.Context, event events.LambdaFunctionURLRequest) (*http.Request, error) {
+	body := []byte(event.Body)
+	if event.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(event.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode request body: %w", err)
+		}
+		body = decoded
+	}
+
+	target := event.RawPath
+	if event.RawQueryString != "" {
+		target += "?" + event.RawQueryString
+	}
+
+	req, err := http.NewRequestWithContext(ctx, event.RequestContext.HTTP.Method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Host = event.RequestContext.DomainName
+	for k, v := range event.Headers {
+		req.Header.Set(k, v)
+	}
+	for _, cookie := range event.Cookies {
+		req.Header.Add("Cookie", cookie)
+	}
+	req.RemoteAddr = event.RequestContext.HTTP.SourceIP
+
+	return req, nil
+}
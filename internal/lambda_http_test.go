@@ -0,0 +1,38 @@
+package reporter
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestLambdaHttpFuncCookies(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "a", Value: "1", Expires: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)})
+		http.SetCookie(w, &http.Cookie{Name: "b", Value: "2"})
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Cookie")
+	})
+
+	event := events.LambdaFunctionURLRequest{RawPath: "/ui/"}
+	event.RequestContext.HTTP.Method = http.MethodGet
+	res, err := NewLambdaHttpFunc(handler)(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"a=1; Expires=Mon, 01 Jan 2024 12:00:00 GMT", "b=2"}
+	if !slices.Equal(res.Cookies, expected) {
+		t.Errorf("Expected cookies %q, got %q", expected, res.Cookies)
+	}
+	if _, ok := res.Headers["Set-Cookie"]; ok {
+		t.Errorf("Expected no joined Set-Cookie header, got %q", res.Headers["Set-Cookie"])
+	}
+	if res.Headers["Vary"] != "Origin,Cookie" {
+		t.Errorf("Expected other headers joined, got %q", res.Headers["Vary"])
+	}
+}
@@ -1,233 +1,443 @@
 package reporter
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"strconv"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"text/tabwriter"
+	"time"
+	"unicode"
 
-	"github.com/aws/aws-sdk-go/aws/session"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func Run() {
-	awsSess, err := session.NewSession()
-	if err != nil {
-		log.Fatal("aws session creation failed: ", err)
-	}
+const (
+	ScrapeMode      = "scrape"
+	FlushOutboxMode = "flush-outbox"
+)
+
+// Modes that were requested for Lambda events, but whose features don't exist.
+var unsupportedModes = []string{"flush-digests", "weekly-stats"}
+
+// Fetches stop this long before the run deadline, leaving time to enqueue
+// notifications, write rule state and send.
+const fetchDeadlineReserve = 15 * time.Second
+
+type RunOptions struct {
+	Mode            string
+	DryRun          bool
+	Rules           []string
+	Tags            []string
+	OutDir          string
+	DefaultInterval time.Duration
+	FetchSpread     time.Duration
+}
+
+type ruleRun struct {
+	rule     RetrievalRule
+	report   *RuleReport
+	listings []Listing
+	emails   []Email
+	// putState is false when the run changed no state worth a write.
+	putState bool
+}
 
-	rulesStore := NewRulesStore(awsSess)
+func (r *Reporter) run(ctx context.Context, opts RunOptions, report *RunReport) error {
+	logger := report.Logger()
 
-	emailClient, rules, err := getEmailClientAndRules(awsSess, rulesStore)
+	if !opts.DryRun && (r.outbox == nil || r.notifier == nil) {
+		return fmt.Errorf("outbox and notifier are required unless dry running")
+	}
+
+	start := time.Now()
+	rules, err := getRules(ctx, r.rules)
+	report.Track(loadStage, start)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	rulesSitesContent, err := fetchAllRulesSites(rules)
+	now := r.now()
+
+	rules, err = selectRules(logger, rules, opts, now)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if len(opts.Rules) == 0 {
+		rules = filterDueRules(logger, rules, now, opts.DefaultInterval)
+	}
+	if len(rules) == 0 {
+		logger.Info("no rules due")
+		return nil
 	}
 
-	rulesToUpdate := []RetrievalRule{}
-	emails := []Email{}
+	start = time.Now()
+	fetchCtx, cancel := reserveContext(ctx, fetchDeadlineReserve)
+	rulesSitesContent := fetchAllRulesSites(fetchCtx, r.source, rules, opts.FetchSpread)
+	cancel()
+	report.Track(fetchStage, start)
 
+	runs := []*ruleRun{}
+
+	start = time.Now()
 	for _, rule := range rules {
-		log.Println("processing config: " + rule.Name)
+		ruleLogger := logger.With("rule", rule.Name)
+		ruleLogger.Info("processing rule", "url", rule.Url, "cutoffs", rule.Cutoffs)
 
-		printRule("config", rule)
+		run := &ruleRun{rule: rule, report: report.AddRule(rule.Name)}
 
-		siteContent, ok := rulesSitesContent[rule.Name]
+		site, ok := rulesSitesContent[rule.Name]
 		if !ok {
-			log.Fatalln("site contents not found for rule: ", rule.Name)
+			run.report.Fail("site contents not found")
+			continue
+		}
+		run.report.FetchDuration = site.duration
+		if site.err != nil {
+			run.report.Fail("site fetch failed: %s", site.err)
+			continue
 		}
+		run.report.Fetched = true
 
-		listings, err := Parse(siteContent)
+		_, parseSpan := tracer.Start(ctx, "parse", trace.WithAttributes(attribute.String("rule", rule.Name)))
+		listings, stats, err := Parse(site.content)
+		parseSpan.SetAttributes(attribute.Int("parse.rows", stats.Rows), attribute.Int("parse.parsed", stats.Parsed))
+		endSpan(parseSpan, err)
 		if err != nil {
-			log.Println("site parse failed: ", err)
+			run.report.Fail("site parse failed: %s", err)
+			continue
+		}
+		logListings(ruleLogger, "unfiltered", listings)
+		run.report.Rows = stats.Rows
+		run.report.Parsed = len(listings)
+		run.listings = listings
+		if len(stats.Skipped) > 0 {
+			ruleLogger.Warn("rows skipped", "stage", parseStage, "skipped", stats.SkippedSummary())
+		}
+		if alert := CheckParseHealth(&run.rule, stats, r.alertConfig); alert != nil {
+			run.report.Alert = alert.Message
 		}
-		printListings("unfiltered", listings)
 
-		newCutoffs := GetNewCutoffs(listings)
-		log.Println("new cutoffs: " + strings.Join(newCutoffs, ", "))
+		cutoffs := rule.CurrentCutoffs()
+		if len(cutoffs) == 0 && len(rule.Cutoffs) > 0 {
+			ruleLogger.Info("search url changed, only recording cutoffs", "cutoffs_url", rule.CutoffsUrl)
+		}
+		newCutoffs, cutoffsUrl := GetNewCutoffs(listings), SearchUrl(rule.Url, rule.Filters)
+		if len(newCutoffs) == 0 {
+			newCutoffs, cutoffsUrl = rule.Cutoffs, rule.CutoffsUrl
+		}
 
-		listings = FilterCutoff(listings, rule.Cutoffs)
-		printListings("cutoff filtered", listings)
+		_, filterSpan := tracer.Start(ctx, "filter.cutoff", trace.WithAttributes(attribute.String("rule", rule.Name)))
+		listings = FilterCutoff(listings, cutoffs)
+		filterSpan.End()
+		logListings(ruleLogger, "cutoff filtered", listings)
+		run.report.New = len(listings)
 
+		_, filterSpan = tracer.Start(ctx, "filter.rule", trace.WithAttributes(attribute.String("rule", rule.Name)))
 		listings = FilterRule(listings, rule.Filters)
-		printListings("rules filtered", listings)
+		filterSpan.End()
+		logListings(ruleLogger, "rules filtered", listings)
+		run.report.Filtered = len(listings)
 
-		if len(rule.Cutoffs) > 0 {
+		if rule.IsPaused(now) {
+			ruleLogger.Info("rule paused", "paused_until", rule.PausedUntil)
+		} else if len(cutoffs) > 0 {
 			for _, listing := range listings {
-				emails = append(emails, Email{To: rule.Email, Listing: listing})
+				run.emails = append(run.emails, Email{To: rule.Email, Rule: rule.Name, Listing: listing})
 			}
-			log.Printf("sending %d emails\n", len(listings))
 		}
+		ruleLogger.Info("rule processed", "new_cutoffs", newCutoffs, "emails", len(run.emails))
+
+		run.rule.Cutoffs = newCutoffs
+		run.rule.CutoffsUrl = cutoffsUrl
+		// A rule without an interval is due on every invocation, so recording
+		// that it was checked is not worth a write on its own.
+		interval, _ := rule.Interval(opts.DefaultInterval)
+		run.putState = interval > 0 || !reflect.DeepEqual(run.rule.State(), rule.State())
+		if run.putState {
+			run.rule.LastChecked = &now
+		}
+		runs = append(runs, run)
+	}
+	report.Track(parseStage, start)
+
+	if opts.DryRun {
+		return reportDryRun(rules, runs, opts.OutDir)
+	}
 
-		if !isCutoffsEqual(rule.Cutoffs, newCutoffs) {
-			rule.Cutoffs = newCutoffs
-			rulesToUpdate = append(rulesToUpdate, rule)
+	start = time.Now()
+	err = commitAndDeliver(ctx, r.rules, r.outbox, r.notifier, runs, report, now)
+	report.Track(deliverStage, start)
+
+	if alerts := report.Alerts(); len(alerts) > 0 {
+		if r.alerter == nil {
+			logger.Warn("parser alerts raised, but no alerter is configured", "alerts", len(alerts))
+		} else if alertErr := r.alerter.SendAlerts(ctx, alerts); alertErr != nil {
+			logger.Error("sending parser alerts failed", "error", alertErr)
 		}
 	}
 
-	err = updateRulesSendEmails(rulesStore, emailClient, rulesToUpdate, emails)
-	if err != nil {
-		log.Fatal(err)
+	if history, ok := r.rules.(ListingHistory); ok {
+		start = time.Now()
+		historyErr := recordHistory(ctx, history, runs)
+		report.Track(historyStage, start)
+		if historyErr != nil {
+			logger.Error("listing history recording failed", "error", historyErr)
+		}
 	}
+
+	return err
 }
 
-func getEmailClientAndRules(awsSess *session.Session, rulesStore *RulesStore) (*EmailClient, []RetrievalRule, error) {
-	type emailResult struct {
-		client *EmailClient
-		err    error
-	}
+func recordHistory(ctx context.Context, history ListingHistory, runs []*ruleRun) (err error) {
+	_, span := tracer.Start(ctx, "history.record")
+	defer func() { endSpan(span, err) }()
 
-	type rulesResult struct {
-		err   error
-		rules []RetrievalRule
+	delivered := []Email{}
+	for _, run := range runs {
+		err = history.RecordListings(ctx, run.rule.Name, run.listings)
+		if err != nil {
+			return err
+		}
+		if !run.report.IsFailed() {
+			delivered = append(delivered, run.emails...)
+		}
 	}
+	return history.RecordDelivered(ctx, delivered)
+}
 
-	emailChan := make(chan emailResult)
-	rulesChan := make(chan rulesResult)
+func getRules(ctx context.Context, rulesStore RulesStore) ([]RetrievalRule, error) {
+	_, span := tracer.Start(ctx, "rules.get")
+	rules, err := rulesStore.Get(ctx)
+	endSpan(span, err)
+	return rules, err
+}
 
-	go func() {
-		credentialsBucket := NewCredentialsBucket(awsSess)
-		emailConfig, emailToken, err := getEmailClientFiles(credentialsBucket)
+func filterDueRules(logger *slog.Logger, rules []RetrievalRule, now time.Time, defaultInterval time.Duration) []RetrievalRule {
+	filtered := []RetrievalRule{}
+	for _, rule := range rules {
+		due, err := rule.IsDue(now, defaultInterval)
 		if err != nil {
-			emailChan <- emailResult{err: err, client: nil}
-			return
+			logger.Warn("invalid check interval", "rule", rule.Name, "error", err)
+		}
+		if due {
+			filtered = append(filtered, rule)
 		}
-		client, err := NewEmailClient(emailConfig, emailToken)
+	}
+	return filtered
+}
+
+// selectRules narrows rules to the requested names and tags and drops
+// disabled and expired rules. Unknown rule names are an error.
+func selectRules(logger *slog.Logger, rules []RetrievalRule, opts RunOptions, now time.Time) ([]RetrievalRule, error) {
+	var err error
+	if len(opts.Rules) > 0 {
+		rules, err = filterRulesByNames(rules, opts.Rules)
 		if err != nil {
-			err = fmt.Errorf("email client creation failed: %w", err)
+			return nil, err
 		}
-		emailChan <- emailResult{err: err, client: client}
-	}()
+	}
 
-	go func() {
-		rules, err := rulesStore.Get()
-		rulesChan <- rulesResult{err: err, rules: rules}
-	}()
+	filtered := []RetrievalRule{}
+	for _, rule := range rules {
+		if len(opts.Tags) > 0 && !rule.HasAnyTag(opts.Tags) {
+			continue
+		}
+		if !rule.IsActive(now) {
+			logger.Debug("rule inactive", "rule", rule.Name, "enabled", rule.IsEnabled(), "active_from", rule.ActiveFrom, "active_until", rule.ActiveUntil)
+			continue
+		}
+		filtered = append(filtered, rule)
+	}
+	return filtered, nil
+}
 
-	emailRes := <-emailChan
-	if emailRes.err != nil {
-		return nil, nil, fmt.Errorf("config retrieval for email failed: %w", emailRes.err)
+func filterRulesByNames(rules []RetrievalRule, names []string) ([]RetrievalRule, error) {
+	filtered := []RetrievalRule{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		// A rule named twice would run twice at once and race on its state.
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		i := slices.IndexFunc(rules, func(rule RetrievalRule) bool { return rule.Name == name })
+		if i == -1 {
+			return nil, fmt.Errorf("rule not found: %s", name)
+		}
+		filtered = append(filtered, rules[i])
 	}
+	return filtered, nil
+}
 
-	rulesRes := <-rulesChan
-	if rulesRes.err != nil {
-		return nil, nil, fmt.Errorf("token retrieval for email failed: %w", rulesRes.err)
+func reportDryRun(rules []RetrievalRule, runs []*ruleRun, outDir string) error {
+	oldCutoffs := map[string][]string{}
+	for _, rule := range rules {
+		oldCutoffs[rule.Name] = rule.Cutoffs
 	}
 
-	return emailRes.client, rulesRes.rules, nil
+	cutoffRows := [][]string{}
+	emails := []Email{}
+	for _, run := range runs {
+		emails = append(emails, run.emails...)
+		if isCutoffsEqual(oldCutoffs[run.rule.Name], run.rule.Cutoffs) {
+			continue
+		}
+		cutoffRows = append(cutoffRows, []string{
+			run.rule.Name,
+			strings.Join(oldCutoffs[run.rule.Name], ","),
+			strings.Join(run.rule.Cutoffs, ","),
+		})
+	}
+	printCsv("dry run cutoff changes", []string{"rule", "old", "new"}, cutoffRows)
+
+	emailRows := [][]string{}
+	for _, email := range emails {
+		emailRows = append(emailRows, []string{
+			email.Rule,
+			email.To,
+			email.Listing.Id,
+			email.Listing.Url,
+		})
+	}
+	printCsv("dry run emails", []string{"rule", "to", "id", "url"}, emailRows)
+
+	if outDir == "" {
+		return nil
+	}
+	return writeEmailFiles(outDir, emails)
 }
 
-func getEmailClientFiles(bucket *CredentialsBucket) ([]byte, []byte, error) {
-	type result struct {
-		err  error
-		file []byte
+func writeEmailFiles(outDir string, emails []Email) error {
+	err := os.MkdirAll(outDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create output dir: %w", err)
 	}
 
-	configChan := make(chan result)
-	tokenChan := make(chan result)
+	links := NewSubscriptionLinksFromEnv()
+	for _, email := range emails {
+		rendered := RenderListingEmail(email, links)
+		name := filepath.Join(outDir, fileSafeName(email.Rule)+"-"+email.Listing.Id)
 
-	get := func(name string, target chan result) {
-		content, err := bucket.Get(name)
-		target <- result{err: err, file: content}
+		err = os.WriteFile(name+".eml", []byte(rendered.Raw()), 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write email file: %w", err)
+		}
+		err = os.WriteFile(name+".html", []byte(rendered.Body), 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write email file: %w", err)
+		}
 	}
+	slog.Info("wrote dry run emails", "emails", len(emails), "dir", outDir)
 
-	go get("credentials.json", configChan)
-	go get("token.json", tokenChan)
+	return nil
+}
 
-	configRes := <-configChan
-	if configRes.err != nil {
-		return nil, nil, fmt.Errorf("config retrieval for email failed: %w", configRes.err)
+func fileSafeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func PreviewRule(ctx context.Context, rule RetrievalRule) ([]Listing, error) {
+	content, err := Fetch(ctx, SearchUrl(rule.Url, rule.Filters))
+	if err != nil {
+		return nil, fmt.Errorf("site fetch failed: %w", err)
 	}
 
-	tokenRes := <-tokenChan
-	if tokenRes.err != nil {
-		return nil, nil, fmt.Errorf("token retrieval for email failed: %w", tokenRes.err)
+	listings, _, err := Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("site parse failed: %w", err)
 	}
 
-	return configRes.file, tokenRes.file, nil
+	return FilterRule(listings, rule.Filters), nil
+}
+
+type siteResult struct {
+	err      error
+	content  string
+	duration time.Duration
 }
 
-func fetchAllRulesSites(rules []RetrievalRule) (map[string]string, error) {
-	urls := map[string][]string{}
+func fetchAllRulesSites(ctx context.Context, source Source, rules []RetrievalRule, spread time.Duration) map[string]siteResult {
+	urls := []string{}
 	for _, rule := range rules {
-		_, ok := urls[rule.Url]
-		if !ok {
-			urls[rule.Url] = []string{}
-		}
-		urls[rule.Url] = append(urls[rule.Url], rule.Name)
+		urls = append(urls, SearchUrl(rule.Url, rule.Filters))
+	}
+	sites := fetchSites(ctx, source, urls, spread)
+
+	out := map[string]siteResult{}
+	for i, rule := range rules {
+		out[rule.Name] = sites[urls[i]]
+	}
+	return out
+}
+
+// fetchSites fetches each distinct url once and returns the results by url.
+func fetchSites(ctx context.Context, source Source, urlList []string, spread time.Duration) map[string]siteResult {
+	urls := map[string]bool{}
+	for _, url := range urlList {
+		urls[url] = true
 	}
 
-	type siteResult struct {
-		err     error
-		url     string
-		content string
+	type urlResult struct {
+		siteResult
+		url string
 	}
 
 	urlsLen := len(urls)
 
-	sitesChan := make(chan siteResult, urlsLen)
+	sitesChan := make(chan urlResult, urlsLen)
 
+	i := 0
 	for url := range urls {
+		delay := spread * time.Duration(i) / time.Duration(urlsLen)
 		go func(url string) {
-			content, err := Fetch(url)
-			sitesChan <- siteResult{err: err, url: url, content: content}
+			err := sleepContext(ctx, delay)
+			if err != nil {
+				sitesChan <- urlResult{siteResult: siteResult{err: err}, url: url}
+				return
+			}
+			_, span := tracer.Start(ctx, "fetch", trace.WithAttributes(attribute.String("url.path", url)))
+			start := time.Now()
+			content, err := source.Fetch(ctx, url)
+			endSpan(span, err)
+			sitesChan <- urlResult{siteResult: siteResult{err: err, content: content, duration: time.Since(start)}, url: url}
 		}(url)
+		i++
 	}
 
-	out := map[string]string{}
+	out := map[string]siteResult{}
 
 	for i := 0; i < urlsLen; i++ {
 		res := <-sitesChan
-		if res.err != nil {
-			return nil, res.err
-		}
-		for _, name := range urls[res.url] {
-			out[name] = res.content
-		}
+		out[res.url] = res.siteResult
 	}
 
-	return out, nil
+	return out
 }
 
-func printRule(name string, rule RetrievalRule) {
-	headers := []string{"name", "email", "url", "filters", "cutoff"}
-	filters, err := json.Marshal(rule.Filters)
-	if err != nil {
-		log.Fatal("print rule failed: ", err)
-	}
-	rows := [][]string{
-		{
-			rule.Name,
-			rule.Email,
-			rule.Url,
-			string(filters),
-			strings.Join(rule.Cutoffs, ","),
-		},
-	}
-	printCsv(name, headers, rows)
-}
-
-func printListings(name string, listings []Listing) {
-	header := []string{"id", "url", "title", "price"}
-	rows := [][]string{}
+func logListings(logger *slog.Logger, name string, listings []Listing) {
+	if !logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
 	for _, listing := range listings {
-		cleanedTitle := strings.ReplaceAll(listing.Title, "\n", " ")
-		rows = append(rows,
-			[]string{
-				listing.Id,
-				listing.Url,
-				cleanedTitle,
-				strconv.FormatFloat(listing.Price, 'f', -1, 64),
-			},
+		logger.Debug(
+			name,
+			"listing_id", listing.Id,
+			"url", listing.Url,
+			"title", strings.ReplaceAll(listing.Title, "\n", " "),
+			"price", listing.Price,
 		)
 	}
-	printCsv(name, header, rows)
 }
 
 func printCsv(name string, headers []string, rows [][]string) {
@@ -267,33 +477,74 @@ func isCutoffsEqual(a []string, b []string) bool {
 	return true
 }
 
-func updateRulesSendEmails(rulesStore *RulesStore, emailClient *EmailClient, rules []RetrievalRule, emails []Email) error {
-	emailsLen := len(emails)
-
-	rulesChan := make(chan error)
-	emailsChan := make(chan error, emailsLen)
+// putRunState writes the state a run produced. When the state changed during
+// the run, e.g. from a pause link, it is re-read and the write retried once,
+// keeping the fields the run doesn't own.
+func putRunState(ctx context.Context, rulesStore RulesStore, rule RetrievalRule) error {
+	err := rulesStore.PutState(ctx, rule.Name, rule.State(), rule.StateVersion)
+	if !errors.Is(err, ErrVersionConflict) {
+		return err
+	}
 
-	go func() {
-		rulesChan <- rulesStore.PutAll(rules)
-	}()
+	stored, err := rulesStore.GetOne(ctx, rule.Name)
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		return fmt.Errorf("rule %s: %w", rule.Name, ErrVersionConflict)
+	}
+	state := rule.State()
+	state.PausedUntil = stored.PausedUntil
+	return rulesStore.PutState(ctx, rule.Name, state, stored.StateVersion)
+}
 
-	for _, email := range emails {
-		go func() {
-			emailsChan <- emailClient.SendListing(email)
-		}()
+// Notifications are enqueued before cutoffs are advanced, so a failed cutoff
+// update only causes already enqueued notifications to be deduplicated on the
+// next run, and a failed send leaves them pending for the next drain.
+func commitAndDeliver(ctx context.Context, rulesStore RulesStore, outbox Outbox, sender Sender, runs []*ruleRun, report *RunReport, now time.Time) error {
+	committed := []*ruleRun{}
+	emails := []Email{}
+	for _, run := range runs {
+		if run.report.IsFailed() {
+			continue
+		}
+		committed = append(committed, run)
+		emails = append(emails, run.emails...)
 	}
 
-	rulesErr := <-rulesChan
-	if rulesErr != nil {
-		return fmt.Errorf("failed to put rules: %w ", rulesErr)
+	_, span := tracer.Start(ctx, "outbox.enqueue", trace.WithAttributes(attribute.Int("outbox.items", len(emails))))
+	err := outbox.Enqueue(ctx, NewOutboxItems(emails, now))
+	endSpan(span, err)
+	if err != nil {
+		for _, run := range committed {
+			run.report.Fail("failed to enqueue notifications: %s", err)
+		}
+		committed = []*ruleRun{}
 	}
 
-	for i := 0; i < emailsLen; i++ {
-		emailErr := <-emailsChan
-		if emailErr != nil {
-			return fmt.Errorf("failed sending listing email: %w", emailErr)
+	// Only the runtime state is written, so a rule edited during the run
+	// keeps its new definition.
+	_, span = tracer.Start(ctx, "rules.put_state", trace.WithAttributes(attribute.Int("rules.count", len(committed))))
+	errs := []error{}
+	for _, run := range committed {
+		if !run.putState {
+			continue
+		}
+		err = putRunState(ctx, rulesStore, run.rule)
+		if errors.Is(err, ErrVersionConflict) {
+			report.Logger().Warn("rule state changed during run, keeping the newer state", "rule", run.rule.Name)
+			continue
+		}
+		if err != nil {
+			run.report.Fail("failed to put rule state: %s", err)
 		}
+		errs = append(errs, err)
 	}
+	endSpan(span, errors.Join(errs...))
 
-	return nil
+	names := []string{}
+	for _, run := range runs {
+		names = append(names, run.rule.Name)
+	}
+	return DrainOutbox(ctx, outbox, sender, names, report)
 }
@@ -0,0 +1,46 @@
+package reporter
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+const (
+	JsonLogFormat = "json"
+	TextLogFormat = "text"
+)
+
+// ConfigureLogging sets the default slog logger, which the log package also
+// writes through. LOG_FORMAT and LOG_LEVEL override the defaults.
+func ConfigureLogging(defaultFormat string) error {
+	level := slog.LevelInfo
+	if val := os.Getenv("LOG_LEVEL"); val != "" {
+		err := level.UnmarshalText([]byte(val))
+		if err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL: %w", err)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch format := cmp.Or(os.Getenv("LOG_FORMAT"), defaultFormat); format {
+	case JsonLogFormat:
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	case TextLogFormat:
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT: %s", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+func newRunId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,200 @@
+package reporter
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsPrefix = "listing_reporter_"
+
+var fetchDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type metricKey struct {
+	name string
+	rule string
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type counterSpec struct {
+	name  string
+	help  string
+	value func(rule *RuleReport) float64
+}
+
+var counterSpecs = []counterSpec{
+	{"rule_runs_total", "Rule runs.", func(*RuleReport) float64 { return 1 }},
+	{"rule_failures_total", "Rule runs that failed.", func(r *RuleReport) float64 { return boolFloat(r.IsFailed()) }},
+	{"listings_parsed_total", "Listings parsed from fetched pages.", func(r *RuleReport) float64 { return float64(r.Parsed) }},
+	{"listings_cutoff_filtered_total", "Listings dropped as already seen.", func(r *RuleReport) float64 { return float64(r.Parsed - r.New) }},
+	{"listings_rule_filtered_total", "New listings dropped by rule filters.", func(r *RuleReport) float64 { return float64(r.New - r.Filtered) }},
+	{"notifications_sent_total", "Notifications sent.", func(r *RuleReport) float64 { return float64(r.Sent) }},
+	{"notifications_failed_total", "Notifications that failed to send.", func(r *RuleReport) float64 { return float64(r.Failed) }},
+}
+
+// Metrics accumulates run reports for the Prometheus endpoint in daemon mode.
+type Metrics struct {
+	mu             sync.Mutex
+	counters       map[metricKey]float64
+	fetchDurations map[string]*histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		counters:       map[metricKey]float64{},
+		fetchDurations: map[string]*histogram{},
+	}
+}
+
+func (m *Metrics) Observe(report *RunReport) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, rule := range report.Rules {
+		for _, spec := range counterSpecs {
+			m.counters[metricKey{spec.name, rule.Rule}] += spec.value(rule)
+		}
+		if rule.FetchDuration > 0 {
+			h, ok := m.fetchDurations[rule.Rule]
+			if !ok {
+				h = &histogram{counts: make([]uint64, len(fetchDurationBuckets))}
+				m.fetchDurations[rule.Rule] = h
+			}
+			h.observe(rule.FetchDuration.Seconds())
+		}
+	}
+}
+
+func (h *histogram) observe(val float64) {
+	for i, bound := range fetchDurationBuckets {
+		if val <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += val
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WritePrometheus(w)
+}
+
+func (m *Metrics) WritePrometheus(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rules := map[string]bool{}
+	for key := range m.counters {
+		rules[key.rule] = true
+	}
+	ruleNames := slices.Sorted(maps.Keys(rules))
+
+	for _, spec := range counterSpecs {
+		name := metricsPrefix + spec.name
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, spec.help, name)
+		for _, rule := range ruleNames {
+			fmt.Fprintf(w, "%s{rule=%s} %s\n", name, quoteLabel(rule), formatFloat(m.counters[metricKey{spec.name, rule}]))
+		}
+	}
+
+	name := metricsPrefix + "fetch_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Duration of site fetches including retries.\n# TYPE %s histogram\n", name, name)
+	for _, rule := range ruleNames {
+		h, ok := m.fetchDurations[rule]
+		if !ok {
+			continue
+		}
+		label := quoteLabel(rule)
+		for i, bound := range fetchDurationBuckets {
+			fmt.Fprintf(w, "%s_bucket{rule=%s,le=\"%s\"} %d\n", name, label, formatFloat(bound), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{rule=%s,le=\"+Inf\"} %d\n", name, label, h.count)
+		fmt.Fprintf(w, "%s_sum{rule=%s} %s\n", name, label, formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count{rule=%s} %d\n", name, label, h.count)
+	}
+}
+
+type emfMetric struct {
+	Name string
+	Unit string
+}
+
+var emfMetrics = []emfMetric{
+	{"ListingsParsed", "Count"},
+	{"ListingsCutoffFiltered", "Count"},
+	{"ListingsRuleFiltered", "Count"},
+	{"NotificationsSent", "Count"},
+	{"NotificationsFailed", "Count"},
+	{"RuleFailed", "Count"},
+	{"FetchDuration", "Milliseconds"},
+}
+
+// WriteEmf writes one CloudWatch Embedded Metric Format record per rule.
+// Lambda forwards stdout to CloudWatch Logs, which extracts the metrics.
+func WriteEmf(w io.Writer, report *RunReport) error {
+	namespace := cmp.Or(os.Getenv("METRICS_NAMESPACE"), "ListingReporter")
+	timestamp := time.Now().UnixMilli()
+
+	for _, rule := range report.Rules {
+		record := map[string]any{
+			"_aws": map[string]any{
+				"Timestamp": timestamp,
+				"CloudWatchMetrics": []any{map[string]any{
+					"Namespace":  namespace,
+					"Dimensions": [][]string{{"Rule"}},
+					"Metrics":    emfMetrics,
+				}},
+			},
+			"Rule":                   rule.Rule,
+			"RunId":                  report.RunId,
+			"ListingsParsed":         rule.Parsed,
+			"ListingsCutoffFiltered": rule.Parsed - rule.New,
+			"ListingsRuleFiltered":   rule.New - rule.Filtered,
+			"NotificationsSent":      rule.Sent,
+			"NotificationsFailed":    rule.Failed,
+			"RuleFailed":             boolFloat(rule.IsFailed()),
+			"FetchDuration":          rule.FetchDuration.Milliseconds(),
+		}
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(line))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func quoteLabel(val string) string {
+	val = strings.ReplaceAll(val, `\`, `\\`)
+	val = strings.ReplaceAll(val, "\n", `\n`)
+	return `"` + strings.ReplaceAll(val, `"`, `\"`) + `"`
+}
+
+func formatFloat(val float64) string {
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
+
+func boolFloat(val bool) float64 {
+	if val {
+		return 1
+	}
+	return 0
+}
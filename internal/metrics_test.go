@@ -0,0 +1,76 @@
+package reporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testMetricsReport() *RunReport {
+	report := NewRunReport(slog.Default())
+	rule := report.AddRule("a")
+	rule.FetchDuration = 300 * time.Millisecond
+	rule.Parsed = 30
+	rule.New = 5
+	rule.Filtered = 2
+	rule.Sent = 1
+	rule.Failed = 1
+	report.AddRule("b").Fail("site fetch failed: %s", "timeout")
+	return report
+}
+
+func TestMetricsPrometheus(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.Observe(testMetricsReport())
+	metrics.Observe(testMetricsReport())
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	expected := []string{
+		`listing_reporter_rule_runs_total{rule="a"} 2`,
+		`listing_reporter_rule_failures_total{rule="b"} 2`,
+		`listing_reporter_listings_parsed_total{rule="a"} 60`,
+		`listing_reporter_listings_cutoff_filtered_total{rule="a"} 50`,
+		`listing_reporter_listings_rule_filtered_total{rule="a"} 6`,
+		`listing_reporter_notifications_sent_total{rule="a"} 2`,
+		`listing_reporter_notifications_failed_total{rule="a"} 2`,
+		`listing_reporter_fetch_duration_seconds_bucket{rule="a",le="0.25"} 0`,
+		`listing_reporter_fetch_duration_seconds_bucket{rule="a",le="0.5"} 2`,
+		`listing_reporter_fetch_duration_seconds_count{rule="a"} 2`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected %q in metrics:\n%s", line, body)
+		}
+	}
+}
+
+func TestWriteEmf(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteEmf(&buf, testMetricsReport())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected a record per rule, got %d", len(lines))
+	}
+	record := map[string]any{}
+	err = json.Unmarshal([]byte(lines[0]), &record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record["Rule"] != "a" || record["ListingsRuleFiltered"] != 3.0 || record["FetchDuration"] != 300.0 {
+		t.Errorf("Unexpected record: %s", lines[0])
+	}
+	if _, ok := record["_aws"]; !ok {
+		t.Errorf("Expected EMF metadata: %s", lines[0])
+	}
+}
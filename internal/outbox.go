@@ -0,0 +1,348 @@
+package reporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	outboxMaxAttempts   = 10
+	outboxSendRetries   = 2
+	outboxRetryBackoff  = 500 * time.Millisecond
+	outboxRetentionTime = 30 * 24 * time.Hour
+	outboxLeaseTime     = 10 * time.Minute
+)
+
+var ErrNotificationClaimed = errors.New("notification is claimed or no longer pending")
+
+// Outbox items are claimed before sending, so overlapping runs don't send the
+// same notification twice. A claim expires after outboxLeaseTime, which lets
+// a later run retry a notification whose sender died mid-send.
+type Outbox interface {
+	Enqueue(ctx context.Context, items []OutboxItem) error
+	Pending(ctx context.Context, rules []string) ([]OutboxItem, error)
+	Claim(ctx context.Context, key string, owner string, until time.Time) error
+	MarkDelivered(ctx context.Context, key string) error
+	MarkFailed(ctx context.Context, key string, reason string) error
+	// MarkDead records a last failed attempt and stops retrying the item.
+	MarkDead(ctx context.Context, key string, reason string) error
+}
+
+type OutboxItem struct {
+	Key          string
+	Email        Email
+	CreatedAt    time.Time
+	Attempts     int
+	LastError    string
+	DeliveredAt  *time.Time
+	DeadAt       *time.Time
+	ClaimedBy    string
+	ClaimedUntil int64
+	ExpiresAt    int64
+}
+
+func (i OutboxItem) isPending() bool {
+	return i.DeliveredAt == nil && i.DeadAt == nil
+}
+
+func (i OutboxItem) isClaimed(now time.Time) bool {
+	return i.ClaimedUntil > now.Unix()
+}
+
+type Sender interface {
+	SendListing(ctx context.Context, email Email) error
+}
+
+func OutboxKey(email Email) string {
+	return email.Rule + "|" + email.To + "|" + email.Listing.Id
+}
+
+func NewOutboxItems(emails []Email, now time.Time) []OutboxItem {
+	items := make([]OutboxItem, len(emails))
+	for i, email := range emails {
+		items[i] = OutboxItem{Key: OutboxKey(email), Email: email, CreatedAt: now}
+	}
+	return items
+}
+
+func DrainOutbox(ctx context.Context, outbox Outbox, sender Sender, rules []string, report *RunReport) error {
+	_, span := tracer.Start(ctx, "outbox.pending")
+	items, err := outbox.Pending(ctx, rules)
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("failed to get pending notifications: %w", err)
+	}
+
+	sendCtx, cancel := sendContext(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var markErr error
+	pending := 0
+
+	for _, item := range items {
+		if item.Attempts >= outboxMaxAttempts {
+			report.Logger().Warn(
+				"notification exceeded max attempts",
+				"stage", deliverStage,
+				"rule", item.Email.Rule,
+				"listing_id", item.Email.Listing.Id,
+				"attempts", item.Attempts,
+			)
+			err := outbox.MarkDead(ctx, item.Key, item.LastError)
+			mu.Lock()
+			markErr = errors.Join(markErr, err)
+			mu.Unlock()
+			continue
+		}
+
+		err := outbox.Claim(ctx, item.Key, report.RunId, time.Now().Add(outboxLeaseTime))
+		if errors.Is(err, ErrNotificationClaimed) {
+			report.Logger().Debug("notification claimed by another run", "stage", deliverStage, "key", item.Key)
+			continue
+		}
+		if err != nil {
+			mu.Lock()
+			markErr = errors.Join(markErr, err)
+			mu.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, span := tracer.Start(ctx, "send", trace.WithAttributes(
+				attribute.String("rule", item.Email.Rule),
+				attribute.String("listing_id", item.Email.Listing.Id),
+				attribute.Int("outbox.attempts", item.Attempts),
+			))
+			var err error
+			sendErr := sendWithRetries(sendCtx, sender, item.Email)
+			isPending := sendErr != nil && sendCtx.Err() != nil
+			if sendErr == nil {
+				err = outbox.MarkDelivered(ctx, item.Key)
+			} else if !isPending && item.Attempts+1 >= outboxMaxAttempts {
+				err = outbox.MarkDead(ctx, item.Key, sendErr.Error())
+			} else if !isPending {
+				err = outbox.MarkFailed(ctx, item.Key, sendErr.Error())
+			}
+			endSpan(span, errors.Join(sendErr, err))
+
+			mu.Lock()
+			defer mu.Unlock()
+			ruleReport := report.Rule(item.Email.Rule)
+			if isPending {
+				ruleReport.Pending++
+				pending++
+			} else if sendErr != nil {
+				ruleReport.Failed++
+				ruleReport.Fail("failed sending listing email: %s", sendErr)
+			} else {
+				ruleReport.Sent++
+			}
+			if err != nil {
+				markErr = errors.Join(markErr, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if pending > 0 {
+		report.Logger().Warn("run deadline reached, notifications left pending", "stage", deliverStage, "pending", pending)
+	}
+	if markErr != nil {
+		return fmt.Errorf("failed to update notification state: %w", markErr)
+	}
+	return nil
+}
+
+// Sends stop this long before the run deadline so their outcome can still be
+// recorded in the outbox. Unsent notifications stay pending for the next run.
+const sendDeadlineReserve = 5 * time.Second
+
+func sendContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return reserveContext(ctx, sendDeadlineReserve)
+}
+
+// reserveContext ends reserve before the deadline of ctx, leaving that time
+// for later stages. At most half of the remaining time is reserved, so a
+// deadline shorter than the reserve still leaves time for this stage.
+func reserveContext(ctx context.Context, reserve time.Duration) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		reserve = min(reserve, time.Until(deadline)/2)
+		return context.WithDeadline(ctx, deadline.Add(-reserve))
+	}
+	return context.WithCancel(ctx)
+}
+
+func sendWithRetries(ctx context.Context, sender Sender, email Email) error {
+	var err error
+	for attempt := 0; attempt <= outboxSendRetries; attempt++ {
+		if attempt > 0 {
+			if sleepErr := sleepContext(ctx, outboxRetryBackoff<<(attempt-1)); sleepErr != nil {
+				return errors.Join(err, sleepErr)
+			}
+		}
+		err = sender.SendListing(ctx, email)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// DynamoOutbox keeps the rule of pending items in PendingRule, which is
+// removed once an item is delivered or dead. The sparse pendingIndex on it
+// lets Pending query the pending items of a rule without scanning the table.
+type DynamoOutbox struct {
+	dynamoSvc *dynamodb.DynamoDB
+	tableName string
+}
+
+const pendingIndex = "pending-index"
+
+func NewDynamoOutbox(awsSess *session.Session) *DynamoOutbox {
+	return &DynamoOutbox{
+		dynamoSvc: dynamodb.New(awsSess),
+		tableName: "listing-reporter-outbox",
+	}
+}
+
+func (o *DynamoOutbox) Enqueue(ctx context.Context, items []OutboxItem) error {
+	for _, item := range items {
+		av, err := dynamodbattribute.MarshalMap(item)
+		if err != nil {
+			return err
+		}
+		av["PendingRule"] = &dynamodb.AttributeValue{S: aws.String(item.Email.Rule)}
+		_, err = o.dynamoSvc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName:           &o.tableName,
+			Item:                av,
+			ConditionExpression: aws.String("attribute_not_exists(#key)"),
+			ExpressionAttributeNames: map[string]*string{
+				"#key": aws.String("Key"),
+			},
+		})
+		if isConditionFailed(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *DynamoOutbox) Pending(ctx context.Context, rules []string) ([]OutboxItem, error) {
+	items := []OutboxItem{}
+	for _, rule := range rules {
+		var unmarshalErr error
+		err := o.dynamoSvc.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+			TableName:              &o.tableName,
+			IndexName:              aws.String(pendingIndex),
+			KeyConditionExpression: aws.String("PendingRule = :rule"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":rule": {S: aws.String(rule)},
+			},
+		}, func(page *dynamodb.QueryOutput, _ bool) bool {
+			for _, av := range page.Items {
+				item := OutboxItem{}
+				unmarshalErr = dynamodbattribute.UnmarshalMap(av, &item)
+				if unmarshalErr != nil {
+					return false
+				}
+				items = append(items, item)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if unmarshalErr != nil {
+			return nil, unmarshalErr
+		}
+	}
+	return items, nil
+}
+
+func (o *DynamoOutbox) Claim(ctx context.Context, key string, owner string, until time.Time) error {
+	_, err := o.dynamoSvc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           &o.tableName,
+		Key:                 map[string]*dynamodb.AttributeValue{"Key": {S: &key}},
+		UpdateExpression:    aws.String("SET ClaimedBy = :owner, ClaimedUntil = :until"),
+		ConditionExpression: aws.String("attribute_exists(PendingRule) AND (attribute_not_exists(ClaimedUntil) OR ClaimedUntil <= :now)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(owner)},
+			":until": {N: aws.String(fmt.Sprint(until.Unix()))},
+			":now":   {N: aws.String(fmt.Sprint(time.Now().Unix()))},
+		},
+	})
+	if isConditionFailed(err) {
+		return fmt.Errorf("notification %s: %w", key, ErrNotificationClaimed)
+	}
+	return err
+}
+
+func (o *DynamoOutbox) MarkDelivered(ctx context.Context, key string) error {
+	now := time.Now()
+	_, err := o.dynamoSvc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:        &o.tableName,
+		Key:              map[string]*dynamodb.AttributeValue{"Key": {S: &key}},
+		UpdateExpression: aws.String("SET DeliveredAt = :deliveredAt, ExpiresAt = :expiresAt REMOVE PendingRule, ClaimedBy, ClaimedUntil"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":deliveredAt": {S: aws.String(now.Format(time.RFC3339Nano))},
+			":expiresAt":   {N: aws.String(fmt.Sprint(now.Add(outboxRetentionTime).Unix()))},
+		},
+	})
+	return err
+}
+
+func (o *DynamoOutbox) MarkFailed(ctx context.Context, key string, reason string) error {
+	_, err := o.dynamoSvc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:        &o.tableName,
+		Key:              map[string]*dynamodb.AttributeValue{"Key": {S: &key}},
+		UpdateExpression: aws.String("SET Attempts = Attempts + :one, LastError = :reason REMOVE ClaimedBy, ClaimedUntil"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":    {N: aws.String("1")},
+			":reason": {S: &reason},
+		},
+	})
+	return err
+}
+
+func (o *DynamoOutbox) MarkDead(ctx context.Context, key string, reason string) error {
+	now := time.Now()
+	_, err := o.dynamoSvc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: &o.tableName,
+		Key:       map[string]*dynamodb.AttributeValue{"Key": {S: &key}},
+		UpdateExpression: aws.String(
+			"SET Attempts = Attempts + :one, LastError = :reason, DeadAt = :deadAt, ExpiresAt = :expiresAt " +
+				"REMOVE PendingRule, ClaimedBy, ClaimedUntil",
+		),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":       {N: aws.String("1")},
+			":reason":    {S: &reason},
+			":deadAt":    {S: aws.String(now.Format(time.RFC3339Nano))},
+			":expiresAt": {N: aws.String(fmt.Sprint(now.Add(outboxRetentionTime).Unix()))},
+		},
+	})
+	return err
+}
+
+func isConditionFailed(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
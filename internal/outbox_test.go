@@ -0,0 +1,197 @@
+package reporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSender struct {
+	mu   sync.Mutex
+	sent []Email
+	fail bool
+}
+
+func (s *fakeSender) SendListing(ctx context.Context, email Email) error {
+	if s.fail {
+		return fmt.Errorf("send failed")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, email)
+	return nil
+}
+
+func TestDrainOutbox(t *testing.T) {
+	outboxes := map[string]func(dir string) (Outbox, error){
+		"file": func(dir string) (Outbox, error) {
+			return NewFileOutbox(filepath.Join(dir, "outbox.json")), nil
+		},
+		"sqlite": func(dir string) (Outbox, error) {
+			return NewSqliteStore(filepath.Join(dir, "test.db"))
+		},
+	}
+
+	for name, newOutbox := range outboxes {
+		outbox, err := newOutbox(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		emails := []Email{
+			{To: "a@example.com", Rule: "a", Listing: Listing{Id: "1"}},
+			{To: "b@example.com", Rule: "b", Listing: Listing{Id: "1"}},
+		}
+		now := time.Now()
+		for i := 0; i < 2; i++ {
+			err = outbox.Enqueue(context.Background(), NewOutboxItems(emails, now))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		sender := &fakeSender{fail: true}
+		report := &RunReport{}
+		err = DrainOutbox(context.Background(), outbox, sender, []string{"a"}, report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Rule("a").Failed != 1 || report.Err() == nil {
+			t.Errorf("%s: expected failed send in report, got %+v", name, report.Rule("a"))
+		}
+
+		sender.fail = false
+		report = &RunReport{}
+		for i := 0; i < 2; i++ {
+			err = DrainOutbox(context.Background(), outbox, sender, []string{"a", "b"}, report)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(sender.sent) != 2 {
+			t.Errorf("%s: expected each notification to be sent once, got %d", name, len(sender.sent))
+		}
+
+		err = outbox.Enqueue(context.Background(), NewOutboxItems(emails[:1], now))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending, err := outbox.Pending(context.Background(), []string{"a", "b"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 0 {
+			t.Errorf("%s: expected delivered notification not to be enqueued again, got %+v", name, pending)
+		}
+	}
+}
+
+type blockingSender struct{}
+
+func (s *blockingSender) SendListing(ctx context.Context, email Email) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestDrainOutboxDeadline(t *testing.T) {
+	outbox := NewFileOutbox(filepath.Join(t.TempDir(), "outbox.json"))
+	err := outbox.Enqueue(context.Background(), NewOutboxItems([]Email{{To: "a@example.com", Rule: "a", Listing: Listing{Id: "1"}}}, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	report := &RunReport{}
+	err = DrainOutbox(ctx, outbox, &blockingSender{}, []string{"a"}, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rule("a").Pending != 1 || report.Err() != nil {
+		t.Errorf("Expected send left pending without failure, got %+v", report.Rule("a"))
+	}
+
+	items, err := outbox.Pending(context.Background(), []string{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Attempts != 0 {
+		t.Errorf("Expected notification pending without a failed attempt, got %+v", items)
+	}
+}
+
+func TestOutboxClaims(t *testing.T) {
+	outboxes := map[string]func(dir string) (Outbox, error){
+		"file": func(dir string) (Outbox, error) {
+			return NewFileOutbox(filepath.Join(dir, "outbox.json")), nil
+		},
+		"sqlite": func(dir string) (Outbox, error) {
+			return NewSqliteStore(filepath.Join(dir, "test.db"))
+		},
+	}
+
+	for name, newOutbox := range outboxes {
+		ctx := context.Background()
+		outbox, err := newOutbox(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		emails := []Email{
+			{To: "a@example.com", Rule: "a", Listing: Listing{Id: "1"}},
+			{To: "a@example.com", Rule: "a", Listing: Listing{Id: "2"}},
+			{To: "a@example.com", Rule: "a", Listing: Listing{Id: "3"}},
+		}
+		err = outbox.Enqueue(ctx, NewOutboxItems(emails, time.Now()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := []string{OutboxKey(emails[0]), OutboxKey(emails[1]), OutboxKey(emails[2])}
+
+		err = outbox.Claim(ctx, keys[0], "other", time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = outbox.Claim(ctx, keys[0], "run", time.Now().Add(time.Minute))
+		if !errors.Is(err, ErrNotificationClaimed) {
+			t.Errorf("%s: expected a claimed notification not to be claimed again, got %v", name, err)
+		}
+		err = outbox.Claim(ctx, keys[1], "crashed", time.Now().Add(-time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The last attempt marks the notification dead.
+		for i := 0; i < outboxMaxAttempts; i++ {
+			err = outbox.MarkFailed(ctx, keys[2], "send failed")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		sender := &fakeSender{}
+		report := &RunReport{RunId: "run"}
+		err = DrainOutbox(ctx, outbox, sender, []string{"a"}, report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sender.sent) != 1 || sender.sent[0].Listing.Id != "2" {
+			t.Errorf("%s: expected only the notification with an expired claim to be sent, got %+v", name, sender.sent)
+		}
+
+		pending, err := outbox.Pending(ctx, []string{"a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 1 || pending[0].Key != keys[0] || pending[0].ClaimedBy != "other" {
+			t.Errorf("%s: expected only the claimed notification to stay pending, got %+v", name, pending)
+		}
+		err = outbox.Claim(ctx, keys[2], "run", time.Now().Add(time.Minute))
+		if !errors.Is(err, ErrNotificationClaimed) {
+			t.Errorf("%s: expected a dead notification not to be claimed, got %v", name, err)
+		}
+	}
+}
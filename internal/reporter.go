@@ -0,0 +1,184 @@
+package reporter
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Source interface {
+	Fetch(ctx context.Context, path string) (string, error)
+}
+
+// ReporterConfig holds the dependencies of a Reporter. Rules is required,
+// Outbox and Notifier are required unless dry running, and the rest default
+// to the environment configured fetcher, no alerts, time.Now and slog.Default.
+type ReporterConfig struct {
+	Rules    RulesStore
+	Outbox   Outbox
+	Notifier Sender
+	Source   Source
+	Alerter  Alerter
+	Alerts   AlertConfig
+	Now      func() time.Time
+	Logger   *slog.Logger
+}
+
+type Reporter struct {
+	rules       RulesStore
+	outbox      Outbox
+	notifier    Sender
+	source      Source
+	alerter     Alerter
+	alertConfig AlertConfig
+	now         func() time.Time
+	logger      *slog.Logger
+}
+
+func NewReporter(config ReporterConfig) *Reporter {
+	r := &Reporter{
+		rules:       config.Rules,
+		outbox:      config.Outbox,
+		notifier:    config.Notifier,
+		source:      config.Source,
+		alerter:     config.Alerter,
+		alertConfig: config.Alerts,
+		now:         config.Now,
+		logger:      config.Logger,
+	}
+	if r.source == nil {
+		r.source = defaultFetcher()
+	}
+	if r.alertConfig == (AlertConfig{}) {
+		r.alertConfig = DefaultAlertConfig()
+	}
+	if r.now == nil {
+		r.now = time.Now
+	}
+	if r.logger == nil {
+		r.logger = slog.Default()
+	}
+	return r
+}
+
+func NewReporterFromEnv(ctx context.Context, dryRun bool) (*Reporter, error) {
+	alertConfig, err := AlertConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	config := ReporterConfig{Alerts: alertConfig}
+
+	config.Rules, err = NewRulesStoreFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return NewReporter(config), nil
+	}
+
+	credentialsStore, err := NewCredentialsStoreFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	emailClient, err := NewEmailClientFromStore(ctx, credentialsStore, NewSubscriptionLinksFromEnv())
+	if err != nil {
+		return nil, err
+	}
+	config.Notifier = emailClient
+	config.Alerter = NewAlerterFromEnv(emailClient)
+
+	config.Outbox, err = NewOutboxFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewReporter(config), nil
+}
+
+func (r *Reporter) Rules() RulesStore {
+	return r.rules
+}
+
+// Run processes the due rules, or only opts.Rules when set, limited to
+// active rules with any of opts.Tags. In flush-outbox mode it only delivers
+// pending notifications. The report is returned even when the run fails; the
+// error covers failures of the whole run, while failures of single rules are
+// recorded in the report.
+func (r *Reporter) Run(ctx context.Context, opts RunOptions) (*RunReport, error) {
+	report := NewRunReport(r.logger)
+	defer report.LogSummary()
+
+	mode := cmp.Or(opts.Mode, ScrapeMode)
+	ctx, span := tracer.Start(ctx, "run", trace.WithAttributes(
+		attribute.String("run.id", report.RunId),
+		attribute.String("run.mode", mode),
+		attribute.StringSlice("run.rules", opts.Rules),
+		attribute.StringSlice("run.tags", opts.Tags),
+		attribute.Bool("run.dry_run", opts.DryRun),
+	))
+	var err error
+	switch mode {
+	case ScrapeMode:
+		err = r.run(ctx, opts, report)
+	case FlushOutboxMode:
+		err = r.flush(ctx, opts, report)
+	default:
+		err = fmt.Errorf("invalid mode %q, expected %s or %s", mode, ScrapeMode, FlushOutboxMode)
+		if slices.Contains(unsupportedModes, mode) {
+			err = fmt.Errorf("mode %q is not supported, there are no digests or weekly stats yet", mode)
+		}
+	}
+	if err != nil {
+		endSpan(span, err)
+	} else {
+		endSpan(span, report.Err())
+	}
+	return report, err
+}
+
+func (r *Reporter) flush(ctx context.Context, opts RunOptions, report *RunReport) error {
+	if opts.DryRun {
+		return fmt.Errorf("dry run is not supported in %s mode", FlushOutboxMode)
+	}
+	if r.outbox == nil || r.notifier == nil {
+		return fmt.Errorf("outbox and notifier are required to flush the outbox")
+	}
+
+	start := time.Now()
+	rules, err := getRules(ctx, r.rules)
+	report.Track(loadStage, start)
+	if err != nil {
+		return err
+	}
+	rules, err = selectRules(report.Logger(), rules, opts, r.now())
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for _, rule := range rules {
+		names = append(names, rule.Name)
+	}
+	start = time.Now()
+	err = DrainOutbox(ctx, r.outbox, r.notifier, names, report)
+	report.Track(deliverStage, start)
+	return err
+}
+
+func Run(ctx context.Context, opts RunOptions) (*RunReport, error) {
+	reporter, err := NewReporterFromEnv(ctx, opts.DryRun)
+	if err != nil {
+		return nil, fmt.Errorf("reporter setup failed: %w", err)
+	}
+
+	report, err := reporter.Run(ctx, opts)
+	if err != nil {
+		return report, err
+	}
+	return report, report.Err()
+}
@@ -0,0 +1,469 @@
+package reporter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeSource struct {
+	content string
+	paths   []string
+}
+
+func (s *fakeSource) Fetch(ctx context.Context, path string) (string, error) {
+	s.paths = append(s.paths, path)
+	return s.content, ctx.Err()
+}
+
+func TestReporterRun(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "fixtures", "flats-riga-centre-sell.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	rules := NewFileRulesStore(filepath.Join(dir, "rules.json"))
+	err = rules.Put(context.Background(), RetrievalRule{
+		Name:       "centre",
+		Email:      "a@example.com",
+		Url:        "/lv/real-estate/flats/riga/centre/sell/",
+		Cutoffs:    []string{"53009874"},
+		CutoffsUrl: "/lv/real-estate/flats/riga/centre/sell/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	source := &fakeSource{content: string(content)}
+	sender := &fakeSender{}
+	reporter := NewReporter(ReporterConfig{
+		Rules:    rules,
+		Outbox:   NewFileOutbox(filepath.Join(dir, "outbox.json")),
+		Notifier: sender,
+		Source:   source,
+		Now:      func() time.Time { return now },
+	})
+
+	report, err := reporter.Run(context.Background(), RunOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Err() != nil {
+		t.Fatal(report.Err())
+	}
+	if len(source.paths) != 1 {
+		t.Errorf("Expected one fetch, got %v", source.paths)
+	}
+	if len(sender.sent) != 1 || sender.sent[0].Listing.Id != "53010111" {
+		t.Errorf("Expected the listing above the cutoff to be sent, got %+v", sender.sent)
+	}
+	ruleReport := report.Rule("centre")
+	if ruleReport.Parsed != 4 || ruleReport.New != 1 || ruleReport.Sent != 1 {
+		t.Errorf("Unexpected rule report: %+v", ruleReport)
+	}
+
+	rule, err := rules.GetOne(context.Background(), "centre")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rule.Cutoffs) != 3 || rule.Cutoffs[0] != "53010111" {
+		t.Errorf("Expected updated cutoffs, got %v", rule.Cutoffs)
+	}
+	if rule.LastChecked == nil || !rule.LastChecked.Equal(now) {
+		t.Errorf("Expected last checked at injected time, got %v", rule.LastChecked)
+	}
+}
+
+func TestReporterRunRequiresNotifier(t *testing.T) {
+	rules := NewFileRulesStore(filepath.Join(t.TempDir(), "rules.json"))
+	_, err := NewReporter(ReporterConfig{Rules: rules}).Run(context.Background(), RunOptions{})
+	if err == nil {
+		t.Error("Expected error without outbox and notifier")
+	}
+}
+
+func TestReporterFlushOutbox(t *testing.T) {
+	dir := t.TempDir()
+	rules := NewFileRulesStore(filepath.Join(dir, "rules.json"))
+	for _, name := range []string{"a", "b"} {
+		err := rules.Put(context.Background(), RetrievalRule{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	outbox := NewFileOutbox(filepath.Join(dir, "outbox.json"))
+	err := outbox.Enqueue(context.Background(), NewOutboxItems([]Email{
+		{To: "a@example.com", Rule: "a", Listing: Listing{Id: "1"}},
+		{To: "b@example.com", Rule: "b", Listing: Listing{Id: "1"}},
+	}, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := &fakeSource{}
+	sender := &fakeSender{}
+	reporter := NewReporter(ReporterConfig{Rules: rules, Outbox: outbox, Notifier: sender, Source: source})
+
+	report, err := reporter.Run(context.Background(), RunOptions{Mode: FlushOutboxMode, Rules: []string{"b", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sender.sent) != 1 || sender.sent[0].Rule != "b" || report.Rule("b").Sent != 1 {
+		t.Errorf("Expected only the b notification to be sent, got %+v", sender.sent)
+	}
+	if len(source.paths) != 0 {
+		t.Errorf("Expected no fetches when flushing, got %v", source.paths)
+	}
+
+	_, err = reporter.Run(context.Background(), RunOptions{Mode: FlushOutboxMode, Rules: []string{"missing"}})
+	if err == nil {
+		t.Error("Expected error for unknown rule")
+	}
+	_, err = reporter.Run(context.Background(), RunOptions{Mode: "weekly-stats"})
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("Expected unsupported mode error, got %v", err)
+	}
+	_, err = reporter.Run(context.Background(), RunOptions{Mode: "unknown"})
+	if err == nil {
+		t.Error("Expected error for unknown mode")
+	}
+}
+
+func TestReporterRunSelectsActiveTaggedRules(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "fixtures", "flats-riga-centre-sell.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	expired := now.Add(-time.Hour)
+	disabled := false
+	rules := &memRulesStore{rules: []RetrievalRule{
+		{Name: "tagged", Url: "/a/", Tags: []string{"riga", "flats"}},
+		{Name: "untagged", Url: "/b/"},
+		{Name: "disabled", Url: "/c/", Tags: []string{"riga"}, Enabled: &disabled},
+		{Name: "expired", Url: "/d/", Tags: []string{"riga"}, ActiveUntil: &expired},
+	}}
+	source := &fakeSource{content: string(content)}
+	reporter := NewReporter(ReporterConfig{
+		Rules:  rules,
+		Source: source,
+		Now:    func() time.Time { return now },
+	})
+
+	report, err := reporter.Run(context.Background(), RunOptions{DryRun: true, Tags: []string{"riga"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rules) != 1 || report.Rules[0].Rule != "tagged" {
+		t.Errorf("Expected only the active tagged rule to run, got %+v", report.Rules)
+	}
+	if len(source.paths) != 1 || source.paths[0] != "/a/" {
+		t.Errorf("Expected only the tagged rule to be fetched, got %v", source.paths)
+	}
+}
+
+// editingSource changes the rule while the run is fetching it.
+type editingSource struct {
+	fakeSource
+	edit func(ctx context.Context) error
+}
+
+func (s *editingSource) Fetch(ctx context.Context, path string) (string, error) {
+	err := s.edit(ctx)
+	if err != nil {
+		return "", err
+	}
+	return s.fakeSource.Fetch(ctx, path)
+}
+
+func TestReporterRunKeepsConcurrentEdit(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "fixtures", "flats-riga-centre-sell.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := &memRulesStore{}
+	err = rules.Put(context.Background(), RetrievalRule{Name: "centre", Email: "a@example.com", Url: "/a/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter := NewReporter(ReporterConfig{
+		Rules:    rules,
+		Outbox:   NewFileOutbox(filepath.Join(t.TempDir(), "outbox.json")),
+		Notifier: &fakeSender{},
+		Source: &editingSource{fakeSource: fakeSource{content: string(content)}, edit: func(ctx context.Context) error {
+			rule, err := rules.GetOne(ctx, "centre")
+			if err != nil {
+				return err
+			}
+			rule.Email = "edited@example.com"
+			return rules.Put(ctx, *rule)
+		}},
+	})
+
+	report, err := reporter.Run(context.Background(), RunOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Err() != nil {
+		t.Fatal(report.Err())
+	}
+
+	rule, err := rules.GetOne(context.Background(), "centre")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Email != "edited@example.com" {
+		t.Errorf("Expected the edit made during the run to be kept, got %s", rule.Email)
+	}
+	if len(rule.Cutoffs) != 3 {
+		t.Errorf("Expected cutoffs from the run, got %v", rule.Cutoffs)
+	}
+}
+
+func TestReporterRunRetriesStateConflict(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "fixtures", "flats-riga-centre-sell.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	pausedUntil := now.Add(24 * time.Hour)
+	rules := &memRulesStore{}
+	err = rules.Put(context.Background(), RetrievalRule{Name: "centre", Email: "a@example.com", Url: "/a/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter := NewReporter(ReporterConfig{
+		Rules:    rules,
+		Outbox:   NewFileOutbox(filepath.Join(t.TempDir(), "outbox.json")),
+		Notifier: &fakeSender{},
+		Now:      func() time.Time { return now },
+		Source: &editingSource{fakeSource: fakeSource{content: string(content)}, edit: func(ctx context.Context) error {
+			return rules.PutState(ctx, "centre", RuleState{PausedUntil: &pausedUntil}, 0)
+		}},
+	})
+
+	report, err := reporter.Run(context.Background(), RunOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Err() != nil {
+		t.Fatalf("Expected a state conflict not to fail the run, got %s", report.Err())
+	}
+
+	rule, err := rules.GetOne(context.Background(), "centre")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.PausedUntil == nil || !rule.PausedUntil.Equal(pausedUntil) {
+		t.Errorf("Expected the pause made during the run to be kept, got %v", rule.PausedUntil)
+	}
+	if len(rule.Cutoffs) != 3 {
+		t.Errorf("Expected cutoffs from the run, got %v", rule.Cutoffs)
+	}
+}
+
+func TestReporterRunSkipsUnchangedStateWrites(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "fixtures", "flats-riga-centre-sell.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	rules := &memRulesStore{}
+	for _, rule := range []RetrievalRule{
+		{Name: "default", Email: "a@example.com", Url: "/a/"},
+		{Name: "interval", Email: "a@example.com", Url: "/a/", CheckInterval: "1m"},
+	} {
+		err = rules.Put(context.Background(), rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	reporter := NewReporter(ReporterConfig{
+		Rules:    rules,
+		Outbox:   NewFileOutbox(filepath.Join(t.TempDir(), "outbox.json")),
+		Notifier: &fakeSender{},
+		Source:   &fakeSource{content: string(content)},
+		Now:      func() time.Time { return now },
+	})
+
+	for range 2 {
+		report, err := reporter.Run(context.Background(), RunOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Err() != nil {
+			t.Fatal(report.Err())
+		}
+		now = now.Add(time.Hour)
+	}
+
+	rule, err := rules.GetOne(context.Background(), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.StateVersion != 1 || !rule.LastChecked.Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("Expected only the run that changed cutoffs to write state, got version %d checked at %v", rule.StateVersion, rule.LastChecked)
+	}
+	rule, err = rules.GetOne(context.Background(), "interval")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.StateVersion != 2 || !rule.LastChecked.Equal(now.Add(-time.Hour)) {
+		t.Errorf("Expected a rule with its own interval to record every check, got version %d checked at %v", rule.StateVersion, rule.LastChecked)
+	}
+}
+
+func TestReporterRunRecordsChecksWithDefaultInterval(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "fixtures", "flats-riga-centre-sell.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	rules := &memRulesStore{}
+	err = rules.Put(context.Background(), RetrievalRule{Name: "default", Email: "a@example.com", Url: "/a/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &fakeSource{content: string(content)}
+	reporter := NewReporter(ReporterConfig{
+		Rules:    rules,
+		Outbox:   NewFileOutbox(filepath.Join(t.TempDir(), "outbox.json")),
+		Notifier: &fakeSender{},
+		Source:   source,
+		Now:      func() time.Time { return now },
+	})
+
+	for _, step := range []time.Duration{time.Hour, 10 * time.Minute, 0} {
+		report, err := reporter.Run(context.Background(), RunOptions{DefaultInterval: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Err() != nil {
+			t.Fatal(report.Err())
+		}
+		now = now.Add(step)
+	}
+
+	rule, err := rules.GetOne(context.Background(), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.StateVersion != 2 || !rule.LastChecked.Equal(now.Add(-10*time.Minute)) {
+		t.Errorf("Expected every check on the default interval to be recorded, got version %d checked at %v", rule.StateVersion, rule.LastChecked)
+	}
+	if len(source.paths) != 2 {
+		t.Errorf("Expected the run before the interval passed to skip the rule, got fetches %v", source.paths)
+	}
+}
+
+func TestReporterRunResetsCutoffsFromOtherUrl(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "fixtures", "flats-riga-centre-sell.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	maxRooms := 2
+	rules := &memRulesStore{}
+	err = rules.Put(context.Background(), RetrievalRule{
+		Name:    "centre",
+		Email:   "a@example.com",
+		Url:     "/lv/real-estate/flats/riga/centre/sell/",
+		Filters: Filters{Rooms: &RangeFilter[int]{To: &maxRooms}},
+		// Taken from the page before the rooms filter was sent to the site.
+		Cutoffs:    []string{"1"},
+		CutoffsUrl: "/lv/real-estate/flats/riga/centre/sell/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := &fakeSender{}
+	reporter := NewReporter(ReporterConfig{
+		Rules:    rules,
+		Outbox:   NewFileOutbox(filepath.Join(t.TempDir(), "outbox.json")),
+		Notifier: sender,
+		Source:   &fakeSource{content: string(content)},
+	})
+
+	report, err := reporter.Run(context.Background(), RunOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Err() != nil {
+		t.Fatal(report.Err())
+	}
+	if len(sender.sent) != 0 {
+		t.Errorf("Expected nothing to be sent for cutoffs from another url, got %+v", sender.sent)
+	}
+
+	rule, err := rules.GetOne(context.Background(), "centre")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rule.Cutoffs) != 3 || rule.CutoffsUrl != SearchUrl(rule.Url, rule.Filters) {
+		t.Errorf("Expected cutoffs to be recorded for the new url, got %v from %s", rule.Cutoffs, rule.CutoffsUrl)
+	}
+}
+
+type blockingSource struct{}
+
+func (s *blockingSource) Fetch(ctx context.Context, path string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestReporterRunReservesTimeAfterFetches(t *testing.T) {
+	rules := &memRulesStore{rules: []RetrievalRule{{Name: "centre", Url: "/a/"}}}
+	reporter := NewReporter(ReporterConfig{
+		Rules:    rules,
+		Outbox:   NewFileOutbox(filepath.Join(t.TempDir(), "outbox.json")),
+		Notifier: &fakeSender{},
+		Source:   &blockingSource{},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	report, err := reporter.Run(ctx, RunOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rule("centre").Fetched || report.Err() == nil {
+		t.Errorf("Expected the slow fetch to fail the rule, got %+v", report.Rule("centre"))
+	}
+	if ctx.Err() != nil {
+		t.Error("Expected fetches to stop before the run deadline")
+	}
+}
+
+func TestReporterRunFetchesWithDeadlineShorterThanReserve(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "fixtures", "flats-riga-centre-sell.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := &memRulesStore{rules: []RetrievalRule{{Name: "centre", Url: "/a/"}}}
+	reporter := NewReporter(ReporterConfig{
+		Rules:    rules,
+		Outbox:   NewFileOutbox(filepath.Join(t.TempDir(), "outbox.json")),
+		Notifier: &fakeSender{},
+		Source:   &fakeSource{content: string(content)},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchDeadlineReserve/2)
+	defer cancel()
+	report, err := reporter.Run(ctx, RunOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Rule("centre").Fetched || report.Err() != nil {
+		t.Errorf("Expected the fetch to run within a deadline shorter than the reserve, got %+v", report.Rule("centre"))
+	}
+}
@@ -1,31 +1,208 @@
 package reporter
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 )
 
-type RulesStore struct {
+const minCheckInterval = time.Minute
+
+var ErrVersionConflict = errors.New("rule was changed concurrently")
+
+// RulesStore keeps the definition and the runtime state of a rule apart, so
+// editing a rule and recording a run never overwrite each other. Both writes
+// are conditional on the version that was read and fail with
+// ErrVersionConflict when it is stale.
+type RulesStore interface {
+	Get(ctx context.Context) ([]RetrievalRule, error)
+	GetOne(ctx context.Context, name string) (*RetrievalRule, error)
+	// Put writes the rule definition and keeps the stored state. A rule with
+	// version 0 is created, or replaces a rule stored before versioning.
+	Put(ctx context.Context, rule RetrievalRule) error
+	// PutState writes the runtime state if the stored state version matches.
+	PutState(ctx context.Context, name string, state RuleState, version int) error
+	Delete(ctx context.Context, name string) error
+}
+
+type DynamoRulesStore struct {
 	dynamoSvc *dynamodb.DynamoDB
 	tableName string
 }
 
-func NewRulesStore(awsSess *session.Session) *RulesStore {
-	return &RulesStore{
+func NewDynamoRulesStore(awsSess *session.Session) *DynamoRulesStore {
+	return &DynamoRulesStore{
 		dynamoSvc: dynamodb.New(awsSess),
 		tableName: "listing-reporter",
 	}
 }
 
 type RetrievalRule struct {
-	Name    string
-	Email   string
-	Url     string
-	Filters Filters
+	Name          string
+	Email         string
+	Url           string
+	Filters       Filters
+	Enabled       *bool
+	Tags          []string
+	Owner         string
+	ActiveFrom    *time.Time
+	ActiveUntil   *time.Time
+	Cutoffs       []string
+	CutoffsUrl    string
+	PausedUntil   *time.Time
+	CheckInterval string
+	LastChecked   *time.Time
+	EmptyRuns     int
+	ParseDegraded bool
+	Version       int
+	StateVersion  int
+}
+
+// RuleState is the part of a rule written by runs and subscribers.
+type RuleState struct {
 	Cutoffs []string
+	// CutoffsUrl is the search URL the cutoffs were taken from.
+	CutoffsUrl    string
+	PausedUntil   *time.Time
+	LastChecked   *time.Time
+	EmptyRuns     int
+	ParseDegraded bool
+}
+
+var ruleStateFields = []string{"Cutoffs", "CutoffsUrl", "PausedUntil", "LastChecked", "EmptyRuns", "ParseDegraded"}
+
+func (r RetrievalRule) State() RuleState {
+	return RuleState{
+		Cutoffs:       r.Cutoffs,
+		CutoffsUrl:    r.CutoffsUrl,
+		PausedUntil:   r.PausedUntil,
+		LastChecked:   r.LastChecked,
+		EmptyRuns:     r.EmptyRuns,
+		ParseDegraded: r.ParseDegraded,
+	}
+}
+
+func (r *RetrievalRule) SetState(state RuleState) {
+	r.Cutoffs = state.Cutoffs
+	r.CutoffsUrl = state.CutoffsUrl
+	r.PausedUntil = state.PausedUntil
+	r.LastChecked = state.LastChecked
+	r.EmptyRuns = state.EmptyRuns
+	r.ParseDegraded = state.ParseDegraded
+}
+
+// mergeDefinition applies Put to a stored rule for stores that rewrite whole
+// rules.
+func mergeDefinition(stored *RetrievalRule, rule RetrievalRule) (RetrievalRule, error) {
+	if stored == nil {
+		if rule.Version != 0 {
+			return rule, fmt.Errorf("rule %s: %w", rule.Name, ErrVersionConflict)
+		}
+		rule.Version = 1
+		rule.StateVersion = 0
+		return rule, nil
+	}
+	if stored.Version != rule.Version {
+		return rule, fmt.Errorf("rule %s: %w", rule.Name, ErrVersionConflict)
+	}
+	rule.SetState(stored.State())
+	rule.StateVersion = stored.StateVersion
+	rule.Version++
+	return rule, nil
+}
+
+// mergeState applies PutState to a stored rule for stores that rewrite whole
+// rules.
+func mergeState(stored *RetrievalRule, name string, state RuleState, version int) (RetrievalRule, error) {
+	if stored == nil || stored.StateVersion != version {
+		return RetrievalRule{}, fmt.Errorf("rule %s: %w", name, ErrVersionConflict)
+	}
+	rule := *stored
+	rule.SetState(state)
+	rule.StateVersion++
+	return rule, nil
+}
+
+// CurrentCutoffs returns the cutoffs if they were taken from the page the rule
+// fetches now. Cutoffs from another search URL, e.g. before the filters were
+// edited, are rarely on the new page, which would make every listing new.
+func (r RetrievalRule) CurrentCutoffs() []string {
+	cutoffsUrl := r.CutoffsUrl
+	// Cutoffs stored before their URL was recorded were taken from the
+	// rule URL, without search params.
+	if cutoffsUrl == "" {
+		cutoffsUrl = r.Url
+	}
+	if cutoffsUrl != SearchUrl(r.Url, r.Filters) {
+		return nil
+	}
+	return r.Cutoffs
+}
+
+func (r RetrievalRule) IsPaused(now time.Time) bool {
+	return r.PausedUntil != nil && now.Before(*r.PausedUntil)
+}
+
+// Rules without the Enabled flag predate it and are enabled.
+func (r RetrievalRule) IsEnabled() bool {
+	return r.Enabled == nil || *r.Enabled
+}
+
+// IsActive reports whether the rule runs at all. Unlike paused rules, inactive
+// rules are not fetched and keep their cutoffs until they become active.
+func (r RetrievalRule) IsActive(now time.Time) bool {
+	if !r.IsEnabled() {
+		return false
+	}
+	if r.ActiveFrom != nil && now.Before(*r.ActiveFrom) {
+		return false
+	}
+	return r.ActiveUntil == nil || now.Before(*r.ActiveUntil)
+}
+
+func (r RetrievalRule) HasAnyTag(tags []string) bool {
+	for _, tag := range tags {
+		if slices.Contains(r.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules are due slightly early so a rule checked on every scheduled invocation
+// is not skipped because of small drifts in invocation time.
+const dueTolerance = time.Minute
+
+func (r RetrievalRule) IsDue(now time.Time, defaultInterval time.Duration) (bool, error) {
+	interval, err := r.Interval(defaultInterval)
+	if r.LastChecked == nil {
+		return true, err
+	}
+	return !now.Add(dueTolerance).Before(r.LastChecked.Add(interval)), err
+}
+
+func (r RetrievalRule) Interval(defaultInterval time.Duration) (time.Duration, error) {
+	if r.CheckInterval == "" {
+		return defaultInterval, nil
+	}
+	interval, err := time.ParseDuration(r.CheckInterval)
+	if err != nil {
+		return defaultInterval, fmt.Errorf("invalid check interval %q: %w", r.CheckInterval, err)
+	}
+	if interval < minCheckInterval {
+		return defaultInterval, fmt.Errorf("check interval %s is shorter than %s", interval, minCheckInterval)
+	}
+	return interval, nil
 }
 
 type Filters struct {
@@ -41,68 +218,156 @@ type RangeFilter[T int | float64] struct {
 	To   *T
 }
 
-func (r *RulesStore) Get() ([]RetrievalRule, error) {
-	res, err := r.dynamoSvc.Scan(&dynamodb.ScanInput{TableName: &r.tableName})
+func (r *DynamoRulesStore) Get(ctx context.Context) ([]RetrievalRule, error) {
+	rules := []RetrievalRule{}
+	var unmarshalErr error
+	err := r.dynamoSvc.ScanPagesWithContext(ctx, &dynamodb.ScanInput{TableName: &r.tableName}, func(page *dynamodb.ScanOutput, _ bool) bool {
+		for _, item := range page.Items {
+			rule := RetrievalRule{}
+			unmarshalErr = dynamodbattribute.UnmarshalMap(item, &rule)
+			if unmarshalErr != nil {
+				return false
+			}
+			rules = append(rules, rule)
+		}
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
-	rules := make([]RetrievalRule, len(res.Items))
-	for i, item := range res.Items {
-		err = dynamodbattribute.UnmarshalMap(item, &rules[i])
-		if err != nil {
-			return nil, err
-		}
+	return rules, unmarshalErr
+}
+
+func (r *DynamoRulesStore) GetOne(ctx context.Context, name string) (*RetrievalRule, error) {
+	res, err := r.dynamoSvc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: &r.tableName,
+		Key:       map[string]*dynamodb.AttributeValue{"Name": {S: &name}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.Item == nil {
+		return nil, nil
+	}
+	rule := &RetrievalRule{}
+	err = dynamodbattribute.UnmarshalMap(res.Item, rule)
+	if err != nil {
+		return nil, err
 	}
-	return rules, nil
+	return rule, nil
 }
 
-func (r *RulesStore) Put(rule RetrievalRule) error {
-	av, err := dynamodbattribute.MarshalMap(rule)
+func (r *DynamoRulesStore) Put(ctx context.Context, rule RetrievalRule) error {
+	input, err := r.putInput(rule)
 	if err != nil {
 		return err
 	}
-	_, err = r.dynamoSvc.PutItem(&dynamodb.PutItemInput{
-		TableName: &r.tableName,
-		Item:      av,
-	})
-	return err
+	return r.update(ctx, rule.Name, input)
 }
 
-func (r *RulesStore) PutAll(rules []RetrievalRule) error {
-	if len(rules) == 0 {
-		return nil
+func (r *DynamoRulesStore) putInput(rule RetrievalRule) (*dynamodb.UpdateItemInput, error) {
+	item, err := dynamodbattribute.MarshalMap(rule)
+	if err != nil {
+		return nil, err
 	}
+	delete(item, "Name")
+	delete(item, "Version")
+	delete(item, "StateVersion")
 
-	if len(rules) > 25 {
-		return fmt.Errorf("cannot write more than 25 items in a single batch")
+	// State attributes are only set when the rule is created.
+	update := newDynamoUpdate()
+	for _, field := range slices.Sorted(maps.Keys(item)) {
+		if slices.Contains(ruleStateFields, field) {
+			update.setIfNotExists(field, item[field])
+		} else {
+			update.set(field, item[field])
+		}
 	}
+	update.set("Version", &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(rule.Version + 1))})
 
-	writeRequests := make([]*dynamodb.WriteRequest, len(rules))
+	condition := "attribute_not_exists(#Version)"
+	if rule.Version != 0 {
+		condition = "#Version = :version"
+		update.values[":version"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(rule.Version))}
+	}
+	return update.input(r.tableName, rule.Name, condition), nil
+}
 
-	for i, rule := range rules {
-		av, err := dynamodbattribute.MarshalMap(rule)
-		if err != nil {
-			return err
-		}
-		writeRequests[i] = &dynamodb.WriteRequest{
-			PutRequest: &dynamodb.PutRequest{
-				Item: av,
-			},
-		}
+func (r *DynamoRulesStore) PutState(ctx context.Context, name string, state RuleState, version int) error {
+	input, err := r.putStateInput(name, state, version)
+	if err != nil {
+		return err
+	}
+	return r.update(ctx, name, input)
+}
+
+func (r *DynamoRulesStore) putStateInput(name string, state RuleState, version int) (*dynamodb.UpdateItemInput, error) {
+	item, err := dynamodbattribute.MarshalMap(state)
+	if err != nil {
+		return nil, err
+	}
+
+	update := newDynamoUpdate()
+	for _, field := range slices.Sorted(maps.Keys(item)) {
+		update.set(field, item[field])
 	}
+	update.set("StateVersion", &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(version + 1))})
 
-	input := &dynamodb.BatchWriteItemInput{
-		RequestItems: map[string][]*dynamodb.WriteRequest{
-			r.tableName: writeRequests,
-		},
+	// DynamoDB rejects expression names and values that are not used, so
+	// each is only added with the condition that uses it.
+	condition := "#StateVersion = :version"
+	if version == 0 {
+		condition = "attribute_exists(#Name) AND attribute_not_exists(#StateVersion)"
+		update.names["#Name"] = aws.String("Name")
+	} else {
+		update.values[":version"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(version))}
 	}
+	return update.input(r.tableName, name, condition), nil
+}
 
-	_, err := r.dynamoSvc.BatchWriteItem(input)
+func (r *DynamoRulesStore) update(ctx context.Context, name string, input *dynamodb.UpdateItemInput) error {
+	_, err := r.dynamoSvc.UpdateItemWithContext(ctx, input)
+	if isConditionFailed(err) {
+		return fmt.Errorf("rule %s: %w", name, ErrVersionConflict)
+	}
 	return err
 }
 
-func (r *RulesStore) Delete(name string) error {
-	_, err := r.dynamoSvc.DeleteItem(&dynamodb.DeleteItemInput{
+type dynamoUpdate struct {
+	sets   []string
+	names  map[string]*string
+	values map[string]*dynamodb.AttributeValue
+}
+
+func newDynamoUpdate() *dynamoUpdate {
+	return &dynamoUpdate{names: map[string]*string{}, values: map[string]*dynamodb.AttributeValue{}}
+}
+
+func (u *dynamoUpdate) input(tableName string, name string, condition string) *dynamodb.UpdateItemInput {
+	return &dynamodb.UpdateItemInput{
+		TableName:                 &tableName,
+		Key:                       map[string]*dynamodb.AttributeValue{"Name": {S: &name}},
+		UpdateExpression:          aws.String("SET " + strings.Join(u.sets, ", ")),
+		ConditionExpression:       &condition,
+		ExpressionAttributeNames:  u.names,
+		ExpressionAttributeValues: u.values,
+	}
+}
+
+func (u *dynamoUpdate) set(field string, val *dynamodb.AttributeValue) {
+	u.names["#"+field] = aws.String(field)
+	u.values[":"+field] = val
+	u.sets = append(u.sets, fmt.Sprintf("#%s = :%s", field, field))
+}
+
+func (u *dynamoUpdate) setIfNotExists(field string, val *dynamodb.AttributeValue) {
+	u.names["#"+field] = aws.String(field)
+	u.values[":"+field] = val
+	u.sets = append(u.sets, fmt.Sprintf("#%s = if_not_exists(#%s, :%s)", field, field, field))
+}
+
+func (r *DynamoRulesStore) Delete(ctx context.Context, name string) error {
+	_, err := r.dynamoSvc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
 		TableName: &r.tableName,
 		Key:       map[string]*dynamodb.AttributeValue{"Name": {S: &name}},
 	})
@@ -0,0 +1,144 @@
+package reporter
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestRetrievalRuleIsDue(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	checkedAt := func(ago time.Duration) *time.Time {
+		t := now.Add(-ago)
+		return &t
+	}
+
+	tests := []struct {
+		rule            RetrievalRule
+		defaultInterval time.Duration
+		expected        bool
+	}{
+		{RetrievalRule{}, 0, true},
+		{RetrievalRule{LastChecked: checkedAt(time.Minute)}, 0, true},
+		{RetrievalRule{LastChecked: checkedAt(10 * time.Minute)}, 15 * time.Minute, false},
+		{RetrievalRule{CheckInterval: "1h", LastChecked: checkedAt(30 * time.Minute)}, 0, false},
+		{RetrievalRule{CheckInterval: "1h", LastChecked: checkedAt(59*time.Minute + 30*time.Second)}, 0, true},
+		{RetrievalRule{CheckInterval: "1h", LastChecked: checkedAt(2 * time.Hour)}, 0, true},
+	}
+
+	for i, test := range tests {
+		due, err := test.rule.IsDue(now, test.defaultInterval)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if due != test.expected {
+			t.Errorf("Test %d: expected due %t, got %t", i, test.expected, due)
+		}
+	}
+}
+
+func TestRetrievalRuleIsActive(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(offset time.Duration) *time.Time {
+		t := now.Add(offset)
+		return &t
+	}
+	enabled, disabled := true, false
+
+	tests := []struct {
+		rule     RetrievalRule
+		expected bool
+	}{
+		{RetrievalRule{}, true},
+		{RetrievalRule{Enabled: &enabled}, true},
+		{RetrievalRule{Enabled: &disabled}, false},
+		{RetrievalRule{ActiveFrom: at(time.Hour)}, false},
+		{RetrievalRule{ActiveFrom: at(-time.Hour), ActiveUntil: at(time.Hour)}, true},
+		{RetrievalRule{ActiveUntil: at(-time.Hour)}, false},
+		{RetrievalRule{Enabled: &disabled, ActiveUntil: at(time.Hour)}, false},
+	}
+
+	for i, test := range tests {
+		if active := test.rule.IsActive(now); active != test.expected {
+			t.Errorf("Test %d: expected active %t, got %t", i, test.expected, active)
+		}
+	}
+}
+
+func TestDynamoRulesStoreUpdatesUseAllExpressionAttributes(t *testing.T) {
+	store := &DynamoRulesStore{tableName: "listing-reporter"}
+	cutoffs := []string{"1"}
+	inputs := map[string]func() (*dynamodb.UpdateItemInput, error){
+		"create": func() (*dynamodb.UpdateItemInput, error) {
+			return store.putInput(RetrievalRule{Name: "a", Url: "/a/"})
+		},
+		"update": func() (*dynamodb.UpdateItemInput, error) {
+			return store.putInput(RetrievalRule{Name: "a", Url: "/a/", Version: 2})
+		},
+		"first state": func() (*dynamodb.UpdateItemInput, error) {
+			return store.putStateInput("a", RuleState{Cutoffs: cutoffs}, 0)
+		},
+		"later state": func() (*dynamodb.UpdateItemInput, error) {
+			return store.putStateInput("a", RuleState{Cutoffs: cutoffs}, 3)
+		},
+	}
+
+	placeholder := regexp.MustCompile(`[#:]\w+`)
+	for name, build := range inputs {
+		input, err := build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		used := map[string]bool{}
+		for _, expr := range []string{*input.UpdateExpression, *input.ConditionExpression} {
+			for _, match := range placeholder.FindAllString(expr, -1) {
+				used[match] = true
+			}
+		}
+		declared := map[string]bool{}
+		for key := range input.ExpressionAttributeNames {
+			declared[key] = true
+		}
+		for key := range input.ExpressionAttributeValues {
+			declared[key] = true
+		}
+		for key := range declared {
+			if !used[key] {
+				t.Errorf("%s: %s is declared but not used in %q and %q", name, key, *input.UpdateExpression, *input.ConditionExpression)
+			}
+		}
+		for key := range used {
+			if !declared[key] {
+				t.Errorf("%s: %s is used but not declared", name, key)
+			}
+		}
+	}
+}
+
+func TestRetrievalRuleCurrentCutoffs(t *testing.T) {
+	maxRooms := 2
+	filters := Filters{Rooms: &RangeFilter[int]{To: &maxRooms}}
+	cutoffs := []string{"1"}
+
+	tests := []struct {
+		rule    RetrievalRule
+		current bool
+	}{
+		{RetrievalRule{Url: "/a/", Cutoffs: cutoffs, CutoffsUrl: "/a/"}, true},
+		{RetrievalRule{Url: "/a/", Cutoffs: cutoffs, CutoffsUrl: "/b/"}, false},
+		{RetrievalRule{Url: "/a/", Filters: filters, Cutoffs: cutoffs, CutoffsUrl: SearchUrl("/a/", filters)}, true},
+		{RetrievalRule{Url: "/a/", Filters: filters, Cutoffs: cutoffs, CutoffsUrl: "/a/"}, false},
+		// Stored before CutoffsUrl existed.
+		{RetrievalRule{Url: "/a/", Cutoffs: cutoffs}, true},
+		{RetrievalRule{Url: "/a/", Filters: filters, Cutoffs: cutoffs}, false},
+	}
+
+	for _, test := range tests {
+		current := test.rule.CurrentCutoffs() != nil
+		if current != test.current {
+			t.Errorf("Expected current %t for cutoffs from %q with url %q, got %t", test.current, test.rule.CutoffsUrl, SearchUrl(test.rule.Url, test.rule.Filters), current)
+		}
+	}
+}
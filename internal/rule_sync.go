@@ -0,0 +1,228 @@
+package reporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	CreateRule = "create"
+	UpdateRule = "update"
+	DeleteRule = "delete"
+)
+
+type RuleChange struct {
+	Action string
+	Name   string
+	Fields []string
+	Rule   RetrievalRule
+}
+
+// Definition returns the rule without runtime state, which is owned by runs
+// and subscribers rather than by whoever edits the rule.
+func (r RetrievalRule) Definition() RetrievalRule {
+	r.SetState(RuleState{})
+	r.Version = 0
+	r.StateVersion = 0
+	return r
+}
+
+// ExportRules writes the definition of each rule to a YAML file named after
+// the rule.
+func ExportRules(dir string, rules []RetrievalRule) error {
+	files := map[string]string{}
+	for _, rule := range rules {
+		file := fileSafeName(rule.Name) + ".yaml"
+		if other, ok := files[file]; ok {
+			return fmt.Errorf("rules %q and %q both export to %s", other, rule.Name, file)
+		}
+		files[file] = rule.Name
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create rules dir: %w", err)
+	}
+	for _, rule := range rules {
+		doc, err := definitionDoc(rule)
+		if err != nil {
+			return err
+		}
+		content, err := marshalYaml(doc)
+		if err != nil {
+			return fmt.Errorf("failed to encode rule %s: %w", rule.Name, err)
+		}
+		err = os.WriteFile(filepath.Join(dir, fileSafeName(rule.Name)+".yaml"), content, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write rule %s: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+// LoadRuleDefinitions reads and validates the rules in the YAML files of dir.
+// Runtime state in the files is ignored.
+func LoadRuleDefinitions(dir string) ([]RetrievalRule, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules dir: %w", err)
+	}
+
+	rules := []RetrievalRule{}
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rule file %s: %w", path, err)
+		}
+		var doc any
+		err = yaml.Unmarshal(content, &doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse rule file %s: %w", path, err)
+		}
+		asJson, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		rule, err := DecodeRule(asJson)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		rules = append(rules, rule.Definition())
+	}
+
+	// An empty or mistyped dir would otherwise plan to delete every rule.
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no rule files found in %s", dir)
+	}
+	err = ValidateRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// PlanRuleSync compares the desired definitions with the stored rules.
+// Updates carry the stored version, so applying a plan fails if a rule was
+// edited in the meantime. Stored rules missing from desired are only deleted
+// with prune, as deleting a rule also drops its cutoffs.
+func PlanRuleSync(current []RetrievalRule, desired []RetrievalRule, prune bool) ([]RuleChange, error) {
+	byName := map[string]RetrievalRule{}
+	for _, rule := range current {
+		byName[rule.Name] = rule
+	}
+
+	changes := []RuleChange{}
+	for _, rule := range desired {
+		existing, ok := byName[rule.Name]
+		if !ok {
+			changes = append(changes, RuleChange{Action: CreateRule, Name: rule.Name, Rule: rule})
+			continue
+		}
+		delete(byName, rule.Name)
+
+		fields, err := changedFields(existing.Definition(), rule)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) > 0 {
+			rule.Version = existing.Version
+			changes = append(changes, RuleChange{Action: UpdateRule, Name: rule.Name, Fields: fields, Rule: rule})
+		}
+	}
+	if prune {
+		for _, name := range slices.Sorted(maps.Keys(byName)) {
+			changes = append(changes, RuleChange{Action: DeleteRule, Name: name})
+		}
+	}
+	return changes, nil
+}
+
+// ApplyRuleSync applies the changes in order and stops at the first failure.
+// It returns the changes applied before it.
+func ApplyRuleSync(ctx context.Context, store RulesStore, changes []RuleChange) ([]RuleChange, error) {
+	for i, change := range changes {
+		var err error
+		switch change.Action {
+		case CreateRule, UpdateRule:
+			err = store.Put(ctx, change.Rule)
+		case DeleteRule:
+			err = store.Delete(ctx, change.Name)
+		}
+		if err != nil {
+			return changes[:i], fmt.Errorf("failed to %s rule %s after %d of %d changes: %w", change.Action, change.Name, i, len(changes), err)
+		}
+	}
+	return changes, nil
+}
+
+func changedFields(a RetrievalRule, b RetrievalRule) ([]string, error) {
+	docA, err := definitionDoc(a)
+	if err != nil {
+		return nil, err
+	}
+	docB, err := definitionDoc(b)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []string{}
+	for _, key := range slices.Sorted(maps.Keys(docA)) {
+		if !reflect.DeepEqual(docA[key], docB[key]) {
+			fields = append(fields, key)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(docB)) {
+		if _, ok := docA[key]; !ok {
+			fields = append(fields, key)
+		}
+	}
+	return fields, nil
+}
+
+// definitionDoc converts the rule definition to its JSON document without
+// empty values, which keeps exported files short.
+func definitionDoc(rule RetrievalRule) (map[string]any, error) {
+	asJson, err := json.Marshal(rule.Definition())
+	if err != nil {
+		return nil, err
+	}
+	doc := map[string]any{}
+	err = json.Unmarshal(asJson, &doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range append(ruleStateFields, "Version", "StateVersion") {
+		delete(doc, field)
+	}
+	pruneEmpty(doc)
+	return doc, nil
+}
+
+func pruneEmpty(doc map[string]any) {
+	for key, val := range doc {
+		if nested, ok := val.(map[string]any); ok {
+			pruneEmpty(nested)
+			if len(nested) == 0 {
+				delete(doc, key)
+			}
+			continue
+		}
+		if list, ok := val.([]any); val == nil || val == "" || ok && len(list) == 0 {
+			delete(doc, key)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package reporter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestExportAndLoadRuleDefinitions(t *testing.T) {
+	dir := t.TempDir()
+	roomsFrom := 2
+	checked := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rules := []RetrievalRule{
+		{
+			Name:        "riga centre",
+			Email:       "a@example.com",
+			Url:         "/lv/real-estate/flats/riga/centre/sell/",
+			Filters:     Filters{Rooms: &RangeFilter[int]{From: &roomsFrom}},
+			Tags:        []string{"riga"},
+			Cutoffs:     []string{"1"},
+			LastChecked: &checked,
+		},
+		{Name: "plain", Email: "b@example.com", Url: "/lv/"},
+	}
+
+	err := ExportRules(dir, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "riga_centre.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Email: a@example.com\nFilters:\n    Rooms:\n        From: 2\nName: riga centre\nTags:\n    - riga\nUrl: /lv/real-estate/flats/riga/centre/sell/\n"
+	if string(content) != expected {
+		t.Errorf("Unexpected export:\n%s", content)
+	}
+
+	loaded, err := LoadRuleDefinitions(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := PlanRuleSync(rules, loaded, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("Expected no changes after export, got %+v", changes)
+	}
+}
+
+func TestLoadRuleDefinitionsRejectsInvalidRules(t *testing.T) {
+	files := map[string]string{
+		"unknown field": "Name: a\nEmail: a@example.com\nUrl: /lv/\nFilter: {}\n",
+		"invalid rule":  "Name: a\nEmail: a\nUrl: /lv/\n",
+	}
+	for name, content := range files {
+		dir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dir, "a.yaml"), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = LoadRuleDefinitions(dir)
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	dir := t.TempDir()
+	for _, file := range []string{"a.yaml", "b.yml"} {
+		err := os.WriteFile(filepath.Join(dir, file), []byte("Name: a\nEmail: a@example.com\nUrl: /lv/\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := LoadRuleDefinitions(dir)
+	if err == nil {
+		t.Error("Expected duplicate name error")
+	}
+
+	_, err = LoadRuleDefinitions(t.TempDir())
+	if err == nil {
+		t.Error("Expected error for a dir without rule files")
+	}
+}
+
+func TestPlanAndApplyRuleSync(t *testing.T) {
+	activeUntil := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &memRulesStore{rules: []RetrievalRule{
+		{Name: "keep", Email: "a@example.com", Url: "/a/", Cutoffs: []string{"1"}},
+		{Name: "edit", Email: "b@example.com", Url: "/b/", Cutoffs: []string{"2"}, EmptyRuns: 1},
+		{Name: "remove", Email: "c@example.com", Url: "/c/"},
+	}}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"keep.yaml": "Name: keep\nEmail: a@example.com\nUrl: /a/\nCutoffs: ['ignored']\n",
+		"edit.yaml": "Name: edit\nEmail: b@example.com\nUrl: /b/\nActiveUntil: 2025-01-01T00:00:00Z\nTags: [temporary]\n",
+		"add.yaml":  "Name: add\nEmail: d@example.com\nUrl: /d/\n",
+	}
+	for file, content := range files {
+		err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	desired, err := LoadRuleDefinitions(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := store.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := func(changes []RuleChange) []string {
+		actions := []string{}
+		for _, change := range changes {
+			actions = append(actions, change.Action+" "+change.Name)
+		}
+		return actions
+	}
+	changes, err := PlanRuleSync(current, desired, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(actions(changes), []string{"create add", "update edit"}) {
+		t.Errorf("Expected no deletes without prune, got %v", actions(changes))
+	}
+	changes, err = PlanRuleSync(current, desired, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(actions(changes), []string{"create add", "update edit", "delete remove"}) {
+		t.Fatalf("Unexpected plan: %v", actions(changes))
+	}
+	if !slices.Equal(changes[1].Fields, []string{"ActiveUntil", "Tags"}) {
+		t.Errorf("Unexpected changed fields: %v", changes[1].Fields)
+	}
+
+	applied, err := ApplyRuleSync(context.Background(), store, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(changes) {
+		t.Errorf("Expected all changes to be applied, got %v", actions(applied))
+	}
+	rules, err := store.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("Expected 3 rules, got %+v", rules)
+	}
+	edited, err := store.GetOne(context.Background(), "edit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(edited.Cutoffs, []string{"2"}) || edited.EmptyRuns != 1 || !edited.ActiveUntil.Equal(activeUntil) {
+		t.Errorf("Expected edit to keep runtime state and gain new fields, got %+v", edited)
+	}
+	kept, err := store.GetOne(context.Background(), "keep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(kept.Cutoffs, []string{"1"}) {
+		t.Errorf("Expected cutoffs from files to be ignored, got %v", kept.Cutoffs)
+	}
+}
+
+func TestApplyRuleSyncReportsAppliedChanges(t *testing.T) {
+	store := &memRulesStore{}
+	err := store.Put(context.Background(), RetrievalRule{Name: "edit", Email: "a@example.com", Url: "/a/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := []RuleChange{
+		{Action: CreateRule, Name: "add", Rule: RetrievalRule{Name: "add", Email: "b@example.com", Url: "/b/"}},
+		// Planned before the rule was edited by someone else.
+		{Action: UpdateRule, Name: "edit", Rule: RetrievalRule{Name: "edit", Email: "c@example.com", Url: "/c/", Version: 7}},
+		{Action: DeleteRule, Name: "other"},
+	}
+	applied, err := ApplyRuleSync(context.Background(), store, changes)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected version conflict, got %v", err)
+	}
+	if len(applied) != 1 || applied[0].Name != "add" {
+		t.Errorf("Expected only the create to be reported as applied, got %+v", applied)
+	}
+}
@@ -0,0 +1,165 @@
+package reporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+// DecodeRule decodes a JSON rule, rejecting unknown fields so a misspelled
+// filter is an error instead of a silently empty filter.
+func DecodeRule(data []byte) (RetrievalRule, error) {
+	rule := RetrievalRule{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&rule)
+	if err != nil {
+		return rule, fmt.Errorf("invalid rule: %w", err)
+	}
+	return rule, nil
+}
+
+// Validate returns all problems with the rule definition joined in one error.
+func (r RetrievalRule) Validate() error {
+	errs := []error{}
+	if strings.TrimSpace(r.Name) == "" {
+		errs = append(errs, fmt.Errorf("name is required"))
+	}
+	if address, err := mail.ParseAddress(r.Email); err != nil {
+		errs = append(errs, fmt.Errorf("invalid email %q: %w", r.Email, err))
+	} else if address.Address != r.Email {
+		errs = append(errs, fmt.Errorf("email %q must be a plain address", r.Email))
+	}
+	if err := validateRulePath(r.Url); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := r.Interval(0); err != nil {
+		errs = append(errs, err)
+	}
+
+	errs = append(errs,
+		validateRange("price", r.Filters.Price, 0),
+		validateRange("rooms", r.Filters.Rooms, 0),
+		validateRange("area", r.Filters.Area, 0),
+		validateRange("floor", r.Filters.Floor, 0),
+	)
+
+	if r.ActiveFrom != nil && r.ActiveUntil != nil && !r.ActiveFrom.Before(*r.ActiveUntil) {
+		errs = append(errs, fmt.Errorf("active from %s is not before active until %s", r.ActiveFrom, r.ActiveUntil))
+	}
+	for _, tag := range r.Tags {
+		if strings.TrimSpace(tag) == "" || strings.Contains(tag, ",") {
+			errs = append(errs, fmt.Errorf("invalid tag %q", tag))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateRulePath(path string) error {
+	u, err := url.Parse(path)
+	if err != nil {
+		return fmt.Errorf("invalid url %q: %w", path, err)
+	}
+	if u.Scheme != "" || u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+		return fmt.Errorf("url must be an ss.lv path starting with /")
+	}
+	if strings.Contains(u.Path, "/msg/") {
+		return fmt.Errorf("url %q is a listing, not a list page", path)
+	}
+	return nil
+}
+
+func validateRange[T int | float64](name string, filter *RangeFilter[T], min T) error {
+	if filter == nil {
+		return nil
+	}
+	if filter.From != nil && *filter.From < min {
+		return fmt.Errorf("%s from %v is below %v", name, *filter.From, min)
+	}
+	if filter.To != nil && *filter.To < min {
+		return fmt.Errorf("%s to %v is below %v", name, *filter.To, min)
+	}
+	if filter.From != nil && filter.To != nil && *filter.From > *filter.To {
+		return fmt.Errorf("%s from %v is greater than to %v", name, *filter.From, *filter.To)
+	}
+	return nil
+}
+
+// ValidateRules validates each rule and checks that names are unique.
+func ValidateRules(rules []RetrievalRule) error {
+	errs := []error{}
+	seen := map[string]bool{}
+	for _, rule := range rules {
+		if seen[rule.Name] {
+			errs = append(errs, fmt.Errorf("duplicate rule name %q", rule.Name))
+		}
+		seen[rule.Name] = true
+
+		if err := rule.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rule %q: %w", rule.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type RuleLint struct {
+	Rule     string
+	Listings int
+	Problems []string
+}
+
+// LintRules validates the rules and test fetches each search to check it
+// still yields listings.
+func LintRules(ctx context.Context, source Source, rules []RetrievalRule) []RuleLint {
+	results := []RuleLint{}
+	counts := map[string]int{}
+	for _, rule := range rules {
+		counts[rule.Name]++
+	}
+
+	fetchable := []string{}
+	for _, rule := range rules {
+		result := RuleLint{Rule: rule.Name}
+		if counts[rule.Name] > 1 {
+			result.Problems = append(result.Problems, "duplicate rule name")
+		}
+		if err := rule.Validate(); err != nil {
+			result.Problems = append(result.Problems, strings.Split(err.Error(), "\n")...)
+		}
+		if validateRulePath(rule.Url) == nil {
+			fetchable = append(fetchable, SearchUrl(rule.Url, rule.Filters))
+		}
+		results = append(results, result)
+	}
+
+	// Results are looked up by search url rather than name, so rules with a
+	// duplicate name are each checked against their own search.
+	sites := fetchSites(ctx, source, fetchable, 0)
+	for i, rule := range rules {
+		if validateRulePath(rule.Url) != nil {
+			continue
+		}
+		site := sites[SearchUrl(rule.Url, rule.Filters)]
+		if site.err != nil {
+			results[i].Problems = append(results[i].Problems, fmt.Sprintf("fetch failed: %s", site.err))
+			continue
+		}
+		listings, stats, err := Parse(site.content)
+		if err != nil {
+			results[i].Problems = append(results[i].Problems, fmt.Sprintf("parse failed: %s", err))
+			continue
+		}
+		results[i].Listings = len(FilterRule(listings, rule.Filters))
+		if stats.Rows == 0 {
+			results[i].Problems = append(results[i].Problems, "no listing rows found")
+		} else if stats.Parsed == 0 {
+			results[i].Problems = append(results[i].Problems, fmt.Sprintf("no rows parsed, skipped: %s", stats.SkippedSummary()))
+		}
+	}
+	return results
+}
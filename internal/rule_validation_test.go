@@ -0,0 +1,119 @@
+package reporter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateRule(t *testing.T) {
+	valid := RetrievalRule{Name: "flat", Email: "user@example.com", Url: "/lv/real-estate/flats/riga/"}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected valid rule, got %s", err)
+	}
+
+	from, to := 3, 2
+	negative := -1.0
+	activeFrom := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	activeUntil := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	invalid := []RetrievalRule{
+		{Email: "user@example.com", Url: "/lv/"},
+		{Name: "flat", Email: "user", Url: "/lv/"},
+		{Name: "flat", Email: "User <user@example.com>", Url: "/lv/"},
+		{Name: "flat", Email: "user@example.com", Url: "https://www.ss.lv/lv/"},
+		{Name: "flat", Email: "user@example.com", Url: "/msg/lv/real-estate/flats/riga/centre/bxkpg.html"},
+		{Name: "flat", Email: "user@example.com", Url: "/lv/", CheckInterval: "1s"},
+		{Name: "flat", Email: "user@example.com", Url: "/lv/", Filters: Filters{Rooms: &RangeFilter[int]{From: &from, To: &to}}},
+		{Name: "flat", Email: "user@example.com", Url: "/lv/", Filters: Filters{Price: &RangeFilter[float64]{From: &negative}}},
+		{Name: "flat", Email: "user@example.com", Url: "/lv/", ActiveFrom: &activeFrom, ActiveUntil: &activeUntil},
+		{Name: "flat", Email: "user@example.com", Url: "/lv/", Tags: []string{""}},
+	}
+	for _, rule := range invalid {
+		if err := rule.Validate(); err == nil {
+			t.Errorf("Expected error for rule %+v", rule)
+		}
+	}
+
+	err := RetrievalRule{Email: "user"}.Validate()
+	if err == nil || len(strings.Split(err.Error(), "\n")) != 3 {
+		t.Errorf("Expected name, email and url errors, got %v", err)
+	}
+}
+
+func TestDecodeRuleRejectsUnknownFields(t *testing.T) {
+	_, err := DecodeRule([]byte(`{"Name": "flat", "Filters": {"Room": {"From": 2}}}`))
+	if err == nil {
+		t.Error("Expected error for unknown field")
+	}
+
+	rule, err := DecodeRule([]byte(`{"Name": "flat", "Filters": {"Rooms": {"From": 2}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *rule.Filters.Rooms.From != 2 {
+		t.Errorf("Unexpected rule: %+v", rule)
+	}
+}
+
+func TestValidateRulesDuplicateNames(t *testing.T) {
+	rule := RetrievalRule{Name: "flat", Email: "user@example.com", Url: "/lv/"}
+	if err := ValidateRules([]RetrievalRule{rule}); err != nil {
+		t.Errorf("Expected valid rules, got %s", err)
+	}
+	if err := ValidateRules([]RetrievalRule{rule, rule}); err == nil {
+		t.Error("Expected duplicate name error")
+	}
+}
+
+type pageSource map[string]string
+
+func (s pageSource) Fetch(ctx context.Context, path string) (string, error) {
+	return s[path], nil
+}
+
+func TestLintRules(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "fixtures", "flats-riga-centre-sell.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := pageSource{"/listings/": string(content), "/empty/": "<html></html>"}
+
+	results := LintRules(context.Background(), source, []RetrievalRule{
+		{Name: "ok", Email: "user@example.com", Url: "/listings/"},
+		{Name: "empty", Email: "user@example.com", Url: "/empty/"},
+		{Name: "invalid", Email: "user", Url: "https://www.ss.lv/lv/"},
+	})
+
+	if len(results[0].Problems) != 0 || results[0].Listings != 4 {
+		t.Errorf("Expected 4 listings and no problems, got %+v", results[0])
+	}
+	if len(results[1].Problems) != 1 || results[1].Problems[0] != "no listing rows found" {
+		t.Errorf("Expected empty page problem, got %+v", results[1])
+	}
+	if len(results[2].Problems) != 2 {
+		t.Errorf("Expected email and url problems without fetching, got %+v", results[2])
+	}
+}
+
+func TestLintRulesDuplicateNames(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "fixtures", "flats-riga-centre-sell.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := pageSource{"/listings/": string(content), "/empty/": "<html></html>"}
+
+	results := LintRules(context.Background(), source, []RetrievalRule{
+		{Name: "same", Email: "user@example.com", Url: "/listings/"},
+		{Name: "same", Email: "user@example.com", Url: "/empty/"},
+	})
+
+	if len(results[0].Problems) != 1 || results[0].Listings != 4 {
+		t.Errorf("Expected duplicate name problem and 4 listings, got %+v", results[0])
+	}
+	if len(results[1].Problems) != 2 || results[1].Problems[1] != "no listing rows found" {
+		t.Errorf("Expected duplicate name and empty page problems, got %+v", results[1])
+	}
+}
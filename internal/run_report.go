@@ -0,0 +1,199 @@
+package reporter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	loadStage    = "load"
+	fetchStage   = "fetch"
+	parseStage   = "parse"
+	deliverStage = "deliver"
+	historyStage = "history"
+)
+
+type RunReport struct {
+	RunId   string
+	Rules   []*RuleReport
+	Timings map[string]time.Duration
+
+	logger *slog.Logger
+}
+
+type RuleReport struct {
+	Rule          string
+	Fetched       bool
+	FetchDuration time.Duration
+	Rows          int
+	Parsed        int
+	New           int
+	Filtered      int
+	Sent          int
+	Failed        int
+	Pending       int
+	Error         string
+	Alert         string
+}
+
+func NewRunReport(logger *slog.Logger) *RunReport {
+	runId := newRunId()
+	return &RunReport{RunId: runId, Timings: map[string]time.Duration{}, logger: logger.With("run_id", runId)}
+}
+
+func (r *RunReport) Logger() *slog.Logger {
+	if r.logger == nil {
+		return slog.With("run_id", r.RunId)
+	}
+	return r.logger
+}
+
+func (r *RunReport) Track(stage string, start time.Time) {
+	r.Timings[stage] += time.Since(start)
+}
+
+func (r *RunReport) AddRule(name string) *RuleReport {
+	rule := &RuleReport{Rule: name}
+	r.Rules = append(r.Rules, rule)
+	return rule
+}
+
+func (r *RunReport) Rule(name string) *RuleReport {
+	for _, rule := range r.Rules {
+		if rule.Rule == name {
+			return rule
+		}
+	}
+	return r.AddRule(name)
+}
+
+func (r *RuleReport) Fail(format string, args ...any) {
+	r.Error = fmt.Sprintf(format, args...)
+}
+
+func (r *RuleReport) IsFailed() bool {
+	return r.Error != ""
+}
+
+func (r *RunReport) Err() error {
+	failures := []string{}
+	for _, rule := range r.Rules {
+		if rule.IsFailed() {
+			failures = append(failures, fmt.Sprintf("%s: %s", rule.Rule, rule.Error))
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d rules failed: %s", len(failures), len(r.Rules), strings.Join(failures, "; "))
+}
+
+func (r *RunReport) Alerts() []Alert {
+	alerts := []Alert{}
+	for _, rule := range r.Rules {
+		if rule.Alert != "" {
+			alerts = append(alerts, Alert{Rule: rule.Rule, Message: rule.Alert})
+		}
+	}
+	return alerts
+}
+
+func (r *RunReport) LogSummary() {
+	failed := 0
+	rules := make([]any, 0, len(r.Rules))
+	for _, rule := range r.Rules {
+		if rule.IsFailed() {
+			failed++
+		}
+		rules = append(rules, slog.Group(
+			rule.Rule,
+			"fetched", rule.Fetched,
+			"fetch_ms", rule.FetchDuration.Milliseconds(),
+			"rows", rule.Rows,
+			"parsed", rule.Parsed,
+			"new", rule.New,
+			"filtered", rule.Filtered,
+			"sent", rule.Sent,
+			"failed", rule.Failed,
+			"pending", rule.Pending,
+			"error", rule.Error,
+			"alert", rule.Alert,
+		))
+	}
+	timings := make([]any, 0, len(r.Timings))
+	for stage, duration := range r.Timings {
+		timings = append(timings, slog.Int64(stage, duration.Milliseconds()))
+	}
+
+	level := slog.LevelInfo
+	if failed > 0 {
+		level = slog.LevelWarn
+	}
+	r.Logger().Log(
+		context.Background(),
+		level,
+		"run summary",
+		"rules_total", len(r.Rules),
+		"rules_failed", failed,
+		slog.Group("rules", rules...),
+		slog.Group("timings_ms", timings...),
+	)
+}
+
+type RunSummary struct {
+	RunId       string           `json:"run_id"`
+	RulesTotal  int              `json:"rules_total"`
+	RulesFailed int              `json:"rules_failed"`
+	Rules       []RuleSummary    `json:"rules"`
+	TimingsMs   map[string]int64 `json:"timings_ms"`
+}
+
+type RuleSummary struct {
+	Rule     string `json:"rule"`
+	Fetched  bool   `json:"fetched"`
+	FetchMs  int64  `json:"fetch_ms"`
+	Rows     int    `json:"rows"`
+	Parsed   int    `json:"parsed"`
+	New      int    `json:"new"`
+	Filtered int    `json:"filtered"`
+	Sent     int    `json:"sent"`
+	Failed   int    `json:"failed"`
+	Pending  int    `json:"pending"`
+	Error    string `json:"error,omitempty"`
+	Alert    string `json:"alert,omitempty"`
+}
+
+func (r *RunReport) Summary() RunSummary {
+	summary := RunSummary{
+		RunId:      r.RunId,
+		RulesTotal: len(r.Rules),
+		Rules:      make([]RuleSummary, 0, len(r.Rules)),
+		TimingsMs:  map[string]int64{},
+	}
+	for _, rule := range r.Rules {
+		if rule.IsFailed() {
+			summary.RulesFailed++
+		}
+		summary.Rules = append(summary.Rules, RuleSummary{
+			Rule:     rule.Rule,
+			Fetched:  rule.Fetched,
+			FetchMs:  rule.FetchDuration.Milliseconds(),
+			Rows:     rule.Rows,
+			Parsed:   rule.Parsed,
+			New:      rule.New,
+			Filtered: rule.Filtered,
+			Sent:     rule.Sent,
+			Failed:   rule.Failed,
+			Pending:  rule.Pending,
+			Error:    rule.Error,
+			Alert:    rule.Alert,
+		})
+	}
+	for stage, duration := range r.Timings {
+		summary.TimingsMs[stage] = duration.Milliseconds()
+	}
+	return summary
+}
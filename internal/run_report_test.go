@@ -0,0 +1,80 @@
+package reporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunReportErr(t *testing.T) {
+	report := &RunReport{}
+	report.AddRule("a").Sent = 2
+	report.AddRule("b")
+
+	if err := report.Err(); err != nil {
+		t.Errorf("Expected no error, got %s", err)
+	}
+
+	report.AddRule("c").Fail("site fetch failed: %s", "timeout")
+
+	err := report.Err()
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if !strings.HasPrefix(err.Error(), "1 of 3 rules failed") || !strings.Contains(err.Error(), "c: site fetch failed: timeout") {
+		t.Errorf("Unexpected error summary: %s", err)
+	}
+}
+
+func TestRunReportLogSummary(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	report := NewRunReport(slog.Default())
+	report.AddRule("a").Sent = 2
+	report.Track(fetchStage, time.Now().Add(-time.Second))
+	report.LogSummary()
+
+	summary := struct {
+		Msg         string `json:"msg"`
+		RunId       string `json:"run_id"`
+		RulesFailed int    `json:"rules_failed"`
+		Rules       map[string]struct {
+			Sent int `json:"sent"`
+		} `json:"rules"`
+		TimingsMs map[string]int64 `json:"timings_ms"`
+	}{}
+	err := json.Unmarshal(buf.Bytes(), &summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Msg != "run summary" || summary.RunId != report.RunId || summary.Rules["a"].Sent != 2 {
+		t.Errorf("Unexpected summary: %s", buf.String())
+	}
+	if summary.TimingsMs[fetchStage] < 1000 {
+		t.Errorf("Expected fetch timing, got %s", buf.String())
+	}
+}
+
+func TestRunReportSummary(t *testing.T) {
+	report := &RunReport{RunId: "run", Timings: map[string]time.Duration{fetchStage: 1500 * time.Millisecond}}
+	report.AddRule("a").Sent = 2
+	report.AddRule("b").Fail("site fetch failed")
+
+	summary, err := json.Marshal(report.Summary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"run_id":"run","rules_total":2,"rules_failed":1,"rules":[` +
+		`{"rule":"a","fetched":false,"fetch_ms":0,"rows":0,"parsed":0,"new":0,"filtered":0,"sent":2,"failed":0,"pending":0},` +
+		`{"rule":"b","fetched":false,"fetch_ms":0,"rows":0,"parsed":0,"new":0,"filtered":0,"sent":0,"failed":0,"pending":0,"error":"site fetch failed"}` +
+		`],"timings_ms":{"fetch":1500}}`
+	if string(summary) != expected {
+		t.Errorf("Unexpected summary: %s", summary)
+	}
+}
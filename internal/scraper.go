@@ -1,11 +1,12 @@
 package reporter
 
 import (
+	"errors"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
+	"log/slog"
+	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -30,108 +31,134 @@ type Listing struct {
 	PricePerM2 float64
 }
 
-func Fetch(path string) (string, error) {
-	res, err := http.Get(baseUrl + path)
+func PathFromUrl(rawUrl string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawUrl))
 	if err != nil {
 		return "", err
 	}
-	defer res.Body.Close()
+	if u.Host != "" && u.Host != "ss.lv" && !strings.HasSuffix(u.Host, ".ss.lv") {
+		return "", fmt.Errorf("not an ss.lv url: %s", rawUrl)
+	}
+	if !strings.HasPrefix(u.Path, "/") {
+		return "", fmt.Errorf("unexpected url path: %s", rawUrl)
+	}
+	path := u.Path
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return path, nil
+}
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return "", err
+type ParseStats struct {
+	Rows    int
+	Parsed  int
+	Ignored int
+	Skipped map[string]int
+}
+
+var errIgnoredRow = errors.New("ignored row")
+
+func (s ParseStats) SuccessRatio() float64 {
+	expected := s.Rows - s.Ignored
+	if expected <= 0 {
+		return 1
 	}
+	return float64(s.Parsed) / float64(expected)
+}
 
-	return string(body), nil
+func (s ParseStats) SkippedSummary() string {
+	reasons := make([]string, 0, len(s.Skipped))
+	for reason, count := range s.Skipped {
+		reasons = append(reasons, fmt.Sprintf("%s=%d", reason, count))
+	}
+	sort.Strings(reasons)
+	return strings.Join(reasons, ", ")
 }
 
-func Parse(b string) ([]Listing, error) {
+func Parse(b string) ([]Listing, ParseStats, error) {
+	stats := ParseStats{Skipped: map[string]int{}}
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(b))
 	if err != nil {
-		return []Listing{}, err
+		return []Listing{}, stats, err
 	}
 	rows := doc.Find("[id^=tr_]")
-	if rows.Length() == 0 {
-		return []Listing{}, fmt.Errorf("no rows found")
-	}
+	stats.Rows = rows.Length()
 
 	listings := []Listing{}
 	rows.Each(func(_ int, row *goquery.Selection) {
 		if isBannerRow(row) {
+			stats.Ignored++
 			return
 		}
 
-		id, err := getId(row)
-		if err != nil {
-			log.Println(err)
-			return
-		}
-		url, err := getHref(row)
-		if err != nil {
-			log.Printf("row %s: %s", id, err)
-			return
-		}
-		title, err := getTextAt(row, 2)
-		if err != nil {
-			log.Printf("row %s: %s", id, err)
-			return
-		}
-		img, err := getImageSrc(row)
-		if err != nil {
-			log.Printf("row %s: %s", id, err)
-			return
-		}
-		street, err := getTextAt(row, 3)
-		if err != nil {
-			log.Printf("row %s: %s", id, err)
-			return
-		}
-		rooms, err := getIntAt(row, 4)
-		if err != nil {
-			if strings.Contains(err.Error(), "Citi") {
-				return
-			}
-			log.Printf("row %s: %s", id, err)
-			return
-		}
-		area, err := getFloatAt(row, 5)
-		if err != nil {
-			log.Printf("row %s: %s", id, err)
+		listing, field, err := parseRow(row)
+		if errors.Is(err, errIgnoredRow) {
+			stats.Ignored++
 			return
 		}
-		floor, floors, err := getFloorAndFloorsAt(row, 6)
 		if err != nil {
-			log.Printf("row %s: %s", id, err)
+			slog.Debug("row skipped", "stage", parseStage, "listing_id", listing.Id, "field", field, "error", err)
+			stats.Skipped[field]++
 			return
 		}
-		series, err := getTextAt(row, 7)
-		if err != nil {
-			log.Printf("row %s: %s", id, err)
-			return
-		}
-		price, err := getPriceAt(row, 9)
-		if err != nil {
-			log.Printf("row %s: %s", id, err)
-			return
-		}
-		listings = append(listings, Listing{
-			Id:         id,
-			Url:        baseUrl + url,
-			Title:      title,
-			Img:        img,
-			Street:     street,
-			Rooms:      rooms,
-			Area:       area,
-			Floor:      floor,
-			Floors:     floors,
-			IsTopFloor: floor == floors,
-			Series:     series,
-			Price:      price,
-			PricePerM2: price / area,
-		})
+		listings = append(listings, listing)
 	})
+	stats.Parsed = len(listings)
+
+	return listings, stats, nil
+}
 
-	return listings, nil
+func parseRow(row *goquery.Selection) (Listing, string, error) {
+	listing := Listing{}
+	var err error
+
+	listing.Id, err = getId(row)
+	if err != nil {
+		return listing, "id", err
+	}
+	url, err := getHref(row)
+	if err != nil {
+		return listing, "url", err
+	}
+	listing.Url = baseUrl + url
+	listing.Title, err = getTextAt(row, 2)
+	if err != nil {
+		return listing, "title", err
+	}
+	listing.Img, err = getImageSrc(row)
+	if err != nil {
+		return listing, "image", err
+	}
+	listing.Street, err = getTextAt(row, 3)
+	if err != nil {
+		return listing, "street", err
+	}
+	listing.Rooms, err = getIntAt(row, 4)
+	if err != nil {
+		if strings.Contains(err.Error(), "Citi") {
+			return listing, "rooms", errIgnoredRow
+		}
+		return listing, "rooms", err
+	}
+	listing.Area, err = getFloatAt(row, 5)
+	if err != nil {
+		return listing, "area", err
+	}
+	listing.Floor, listing.Floors, err = getFloorAndFloorsAt(row, 6)
+	if err != nil {
+		return listing, "floor", err
+	}
+	listing.Series, err = getTextAt(row, 7)
+	if err != nil {
+		return listing, "series", err
+	}
+	listing.Price, err = getPriceAt(row, 9)
+	if err != nil {
+		return listing, "price", err
+	}
+	listing.IsTopFloor = listing.Floor == listing.Floors
+	listing.PricePerM2 = listing.Price / listing.Area
+	return listing, "", nil
 }
 
 func isBannerRow(row *goquery.Selection) bool {
@@ -1,6 +1,7 @@
 package reporter
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -120,7 +121,7 @@ var testBody = `
 `
 
 func TestParse(t *testing.T) {
-	listings, err := Parse(testBody)
+	listings, stats, err := Parse(testBody)
 	if err != nil {
 		t.Error(err)
 	}
@@ -129,4 +130,30 @@ func TestParse(t *testing.T) {
 	if len(listings) != expected {
 		t.Errorf("Expected %d listings, got %d", expected, len(listings))
 	}
+	if stats.Rows != 3 || stats.Parsed != 3 || len(stats.Skipped) != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestParseStats(t *testing.T) {
+	body := strings.Replace(testBody, "<td class=\"msga2-o pp6\" nowrap c=1>4/5</td>", "<td class=\"msga2-o pp6\" nowrap c=1>-</td>", 1)
+
+	listings, stats, err := Parse(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listings) != 2 || stats.Rows != 3 || stats.Skipped["floor"] != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if ratio := stats.SuccessRatio(); ratio > 0.67 || ratio < 0.66 {
+		t.Errorf("Expected ratio 2/3, got %f", ratio)
+	}
+
+	listings, stats, err = Parse("<html><body></body></html>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listings) != 0 || stats.Rows != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
 }
@@ -0,0 +1,129 @@
+package reporter
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	roomsParam = 1
+	areaParam  = 3
+	floorParam = 4
+	priceParam = 8
+)
+
+func SearchUrl(path string, filters Filters) string {
+	params := SearchParams(filters)
+	if len(params) == 0 {
+		return path
+	}
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + params.Encode()
+}
+
+func SearchParams(filters Filters) url.Values {
+	params := url.Values{}
+	setRangeParams(params, priceParam, filters.Price, formatFloatParam)
+	setRangeParams(params, roomsParam, filters.Rooms, strconv.Itoa)
+	setRangeParams(params, areaParam, filters.Area, formatFloatParam)
+	setRangeParams(params, floorParam, filters.Floor, strconv.Itoa)
+	return params
+}
+
+func FiltersFromUrl(rawUrl string) (string, Filters, error) {
+	filters := Filters{}
+
+	path, err := PathFromUrl(rawUrl)
+	if err != nil {
+		return "", filters, err
+	}
+
+	u, err := url.Parse(path)
+	if err != nil {
+		return "", filters, err
+	}
+	query := u.Query()
+
+	filters.Price, err = getRangeParam(query, priceParam, parseFloat)
+	if err != nil {
+		return "", filters, err
+	}
+	filters.Rooms, err = getRangeParam(query, roomsParam, strconv.Atoi)
+	if err != nil {
+		return "", filters, err
+	}
+	filters.Area, err = getRangeParam(query, areaParam, parseFloat)
+	if err != nil {
+		return "", filters, err
+	}
+	filters.Floor, err = getRangeParam(query, floorParam, strconv.Atoi)
+	if err != nil {
+		return "", filters, err
+	}
+
+	for key := range query {
+		if strings.HasPrefix(key, "topt[") {
+			query.Del(key)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), filters, nil
+}
+
+// searchForm splits the filter fields off a search url. ss.lv's filter form
+// posts them, so they are sent as a form body rather than a query.
+func searchForm(rawUrl string) (string, url.Values, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", nil, err
+	}
+	query := u.Query()
+	form := url.Values{}
+	for key, vals := range query {
+		if strings.HasPrefix(key, "topt[") {
+			form[key] = vals
+			query.Del(key)
+		}
+	}
+	if len(form) == 0 {
+		return rawUrl, form, nil
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), form, nil
+}
+
+func setRangeParams[T int | float64](params url.Values, id int, filter *RangeFilter[T], format func(T) string) {
+	if filter == nil {
+		return
+	}
+	if filter.From != nil {
+		params.Set(rangeParamKey(id, "min"), format(*filter.From))
+	}
+	if filter.To != nil {
+		params.Set(rangeParamKey(id, "max"), format(*filter.To))
+	}
+}
+
+func getRangeParam[T int | float64](query url.Values, id int, parse func(string) (T, error)) (*RangeFilter[T], error) {
+	from := strings.TrimSpace(query.Get(rangeParamKey(id, "min")))
+	to := strings.TrimSpace(query.Get(rangeParamKey(id, "max")))
+	filter, err := parseRange(fmt.Sprintf("topt[%d]", id), from, to, parse)
+	if err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+func rangeParamKey(id int, bound string) string {
+	return fmt.Sprintf("topt[%d][%s]", id, bound)
+}
+
+func formatFloatParam(val float64) string {
+	return strconv.FormatFloat(val, 'f', -1, 64)
+}
@@ -0,0 +1,46 @@
+package reporter
+
+import "testing"
+
+func TestSearchParamsRoundTrip(t *testing.T) {
+	priceTo := 90000.0
+	roomsFrom := 2
+	roomsTo := 3
+	isNotTopFloor := true
+
+	filters := Filters{
+		Price:         &RangeFilter[float64]{To: &priceTo},
+		Rooms:         &RangeFilter[int]{From: &roomsFrom, To: &roomsTo},
+		IsNotTopFloor: &isNotTopFloor,
+	}
+
+	url := SearchUrl("/lv/real-estate/flats/riga/centre/sell/", filters)
+
+	path, parsed, err := FiltersFromUrl(baseUrl + url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/lv/real-estate/flats/riga/centre/sell/" {
+		t.Errorf("Expected search params to be stripped from path, got %s", path)
+	}
+	if parsed.Price == nil || parsed.Price.From != nil || *parsed.Price.To != priceTo {
+		t.Errorf("Unexpected price filter %+v", parsed.Price)
+	}
+	if parsed.Rooms == nil || *parsed.Rooms.From != roomsFrom || *parsed.Rooms.To != roomsTo {
+		t.Errorf("Unexpected rooms filter %+v", parsed.Rooms)
+	}
+	if parsed.Area != nil || parsed.Floor != nil {
+		t.Errorf("Expected no area and floor filters, got %+v %+v", parsed.Area, parsed.Floor)
+	}
+	if parsed.IsNotTopFloor != nil {
+		t.Error("Expected top floor filter to have no search param")
+	}
+}
+
+func TestSearchUrlWithoutFilters(t *testing.T) {
+	path := "/lv/real-estate/flats/riga/centre/sell/"
+	if url := SearchUrl(path, Filters{}); url != path {
+		t.Errorf("Expected %s, got %s", path, url)
+	}
+}
@@ -0,0 +1,494 @@
+package reporter
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+var sqliteMigrations = []string{
+	`CREATE TABLE rules (
+		name TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	)`,
+	`CREATE TABLE seen_listings (
+		rule TEXT NOT NULL,
+		listing_id TEXT NOT NULL,
+		first_seen_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (rule, listing_id)
+	)`,
+	`CREATE TABLE notifications (
+		rule TEXT NOT NULL,
+		recipient TEXT NOT NULL,
+		listing_id TEXT NOT NULL,
+		delivered_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (rule, recipient, listing_id)
+	)`,
+	`CREATE TABLE listing_history (
+		listing_id TEXT NOT NULL,
+		observed_at TIMESTAMP NOT NULL,
+		price REAL NOT NULL,
+		data TEXT NOT NULL,
+		PRIMARY KEY (listing_id, observed_at)
+	)`,
+	`CREATE TABLE outbox (
+		key TEXT PRIMARY KEY,
+		rule TEXT NOT NULL,
+		email TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		delivered_at TIMESTAMP
+	)`,
+	`ALTER TABLE outbox ADD COLUMN dead_at TIMESTAMP`,
+	`ALTER TABLE outbox ADD COLUMN claimed_by TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE outbox ADD COLUMN claimed_until INTEGER NOT NULL DEFAULT 0`,
+	`CREATE INDEX outbox_pending ON outbox (rule, created_at) WHERE delivered_at IS NULL AND dead_at IS NULL`,
+}
+
+type ListingHistory interface {
+	RecordListings(ctx context.Context, rule string, listings []Listing) error
+	RecordDelivered(ctx context.Context, emails []Email) error
+}
+
+type SqliteStore struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+type ListingObservation struct {
+	ObservedAt time.Time
+	Listing    Listing
+}
+
+func NewSqliteStore(path string) (*SqliteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database %s: %w", path, err)
+	}
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec("PRAGMA busy_timeout = 5000; PRAGMA journal_mode = WAL;")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to configure sqlite database: %w", err)
+	}
+
+	store := &SqliteStore{db: db, now: time.Now}
+	err = store.migrate()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *SqliteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SqliteStore) migrate() error {
+	_, err := s.db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)")
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	var version int
+	err = s.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(sqliteMigrations[i])
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", i+1)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *SqliteStore) Get(ctx context.Context) ([]RetrievalRule, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT data FROM rules ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []RetrievalRule{}
+	for rows.Next() {
+		var data string
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+		rule := RetrievalRule{}
+		err = json.Unmarshal([]byte(data), &rule)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (s *SqliteStore) GetOne(ctx context.Context, name string) (*RetrievalRule, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, "SELECT data FROM rules WHERE name = ?", name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rule := &RetrievalRule{}
+	err = json.Unmarshal([]byte(data), rule)
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (s *SqliteStore) Put(ctx context.Context, rule RetrievalRule) error {
+	return s.updateRule(ctx, rule.Name, func(stored *RetrievalRule) (RetrievalRule, error) {
+		return mergeDefinition(stored, rule)
+	})
+}
+
+func (s *SqliteStore) PutState(ctx context.Context, name string, state RuleState, version int) error {
+	return s.updateRule(ctx, name, func(stored *RetrievalRule) (RetrievalRule, error) {
+		return mergeState(stored, name, state, version)
+	})
+}
+
+// updateRule reads and writes the rule in one transaction, so the version
+// check in apply sees the row it replaces.
+func (s *SqliteStore) updateRule(ctx context.Context, name string, apply func(stored *RetrievalRule) (RetrievalRule, error)) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var stored *RetrievalRule
+	var data string
+	err = tx.QueryRowContext(ctx, "SELECT data FROM rules WHERE name = ?", name).Scan(&data)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err == nil {
+		stored = &RetrievalRule{}
+		err = json.Unmarshal([]byte(data), stored)
+		if err != nil {
+			return err
+		}
+	}
+
+	rule, err := apply(stored)
+	if err != nil {
+		return err
+	}
+	updated, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO rules (name, data) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET data = excluded.data",
+		rule.Name,
+		string(updated),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SqliteStore) Delete(ctx context.Context, name string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM rules WHERE name = ?", name)
+	return err
+}
+
+func (s *SqliteStore) RecordListings(ctx context.Context, rule string, listings []Listing) error {
+	if len(listings) == 0 {
+		return nil
+	}
+
+	now := s.now().UTC()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, listing := range listings {
+		_, err = tx.ExecContext(ctx,
+			"INSERT OR IGNORE INTO seen_listings (rule, listing_id, first_seen_at) VALUES (?, ?, ?)",
+			rule,
+			listing.Id,
+			now,
+		)
+		if err != nil {
+			return err
+		}
+
+		var lastPrice float64
+		err = tx.QueryRowContext(ctx,
+			"SELECT price FROM listing_history WHERE listing_id = ? ORDER BY observed_at DESC LIMIT 1",
+			listing.Id,
+		).Scan(&lastPrice)
+		if err == nil && lastPrice == listing.Price {
+			continue
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		data, err := json.Marshal(listing)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT OR IGNORE INTO listing_history (listing_id, observed_at, price, data) VALUES (?, ?, ?, ?)",
+			listing.Id,
+			now,
+			listing.Price,
+			string(data),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SqliteStore) RecordDelivered(ctx context.Context, emails []Email) error {
+	if len(emails) == 0 {
+		return nil
+	}
+
+	now := s.now().UTC()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, email := range emails {
+		_, err = tx.ExecContext(ctx,
+			"INSERT OR IGNORE INTO notifications (rule, recipient, listing_id, delivered_at) VALUES (?, ?, ?, ?)",
+			email.Rule,
+			email.To,
+			email.Listing.Id,
+			now,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SqliteStore) IsSeen(ctx context.Context, rule string, listingId string) (bool, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM seen_listings WHERE rule = ? AND listing_id = ?",
+		rule,
+		listingId,
+	).Scan(&count)
+	return count > 0, err
+}
+
+func (s *SqliteStore) IsDelivered(ctx context.Context, email Email) (bool, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM notifications WHERE rule = ? AND recipient = ? AND listing_id = ?",
+		email.Rule,
+		email.To,
+		email.Listing.Id,
+	).Scan(&count)
+	return count > 0, err
+}
+
+func (s *SqliteStore) GetListingHistory(ctx context.Context, listingId string) ([]ListingObservation, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT observed_at, data FROM listing_history WHERE listing_id = ? ORDER BY observed_at",
+		listingId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []ListingObservation{}
+	for rows.Next() {
+		observation := ListingObservation{}
+		var data string
+		err = rows.Scan(&observation.ObservedAt, &data)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(data), &observation.Listing)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, observation)
+	}
+	return history, rows.Err()
+}
+
+func (s *SqliteStore) Enqueue(ctx context.Context, items []OutboxItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, item := range items {
+		email, err := json.Marshal(item.Email)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT OR IGNORE INTO outbox (key, rule, email, created_at) VALUES (?, ?, ?, ?)",
+			item.Key,
+			item.Email.Rule,
+			string(email),
+			item.CreatedAt.UTC(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SqliteStore) Pending(ctx context.Context, rules []string) ([]OutboxItem, error) {
+	if len(rules) == 0 {
+		return []OutboxItem{}, nil
+	}
+
+	args := make([]any, len(rules))
+	for i, rule := range rules {
+		args[i] = rule
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(rules)), ", ")
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT key, email, created_at, attempts, last_error, claimed_by, claimed_until FROM outbox WHERE delivered_at IS NULL AND dead_at IS NULL AND rule IN ("+placeholders+") ORDER BY created_at",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []OutboxItem{}
+	for rows.Next() {
+		item := OutboxItem{}
+		var email string
+		err = rows.Scan(&item.Key, &email, &item.CreatedAt, &item.Attempts, &item.LastError, &item.ClaimedBy, &item.ClaimedUntil)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(email), &item.Email)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (s *SqliteStore) MarkDelivered(ctx context.Context, key string) error {
+	now := s.now().UTC()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE outbox SET delivered_at = ?, claimed_by = '', claimed_until = 0 WHERE key = ?", now, key)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM outbox WHERE delivered_at < ? OR dead_at < ?",
+		now.Add(-outboxRetentionTime),
+		now.Add(-outboxRetentionTime),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SqliteStore) Claim(ctx context.Context, key string, owner string, until time.Time) error {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE outbox SET claimed_by = ?, claimed_until = ? WHERE key = ? AND delivered_at IS NULL AND dead_at IS NULL AND claimed_until <= ?",
+		owner,
+		until.Unix(),
+		key,
+		s.now().Unix(),
+	)
+	if err != nil {
+		return err
+	}
+	claimed, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if claimed == 0 {
+		return fmt.Errorf("notification %s: %w", key, ErrNotificationClaimed)
+	}
+	return nil
+}
+
+func (s *SqliteStore) MarkFailed(ctx context.Context, key string, reason string) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE outbox SET attempts = attempts + 1, last_error = ?, claimed_by = '', claimed_until = 0 WHERE key = ?",
+		reason,
+		key,
+	)
+	return err
+}
+
+func (s *SqliteStore) MarkDead(ctx context.Context, key string, reason string) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE outbox SET attempts = attempts + 1, last_error = ?, dead_at = ?, claimed_by = '', claimed_until = 0 WHERE key = ?",
+		reason,
+		s.now().UTC(),
+		key,
+	)
+	return err
+}
@@ -0,0 +1,121 @@
+package reporter
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSqliteStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewSqliteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rule := range []RetrievalRule{
+		{Name: "a", Email: "a@example.com", Url: "/a/"},
+		{Name: "b", Email: "b@example.com", Url: "/b/"},
+	} {
+		err = store.Put(context.Background(), rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.PutState(context.Background(), "a", RuleState{Cutoffs: []string{"1"}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.PutState(context.Background(), "a", RuleState{}, 0)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected conflict for stale state, got %v", err)
+	}
+	err = store.Put(context.Background(), RetrievalRule{Name: "a", Email: "a@example.com", Url: "/a/", Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Put(context.Background(), RetrievalRule{Name: "a", Email: "a@example.com", Url: "/stale/", Version: 1})
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected conflict for stale definition, got %v", err)
+	}
+	err = store.Delete(context.Background(), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = NewSqliteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	rules, err := store.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Name != "a" || len(rules[0].Cutoffs) != 1 {
+		t.Errorf("Unexpected rules after reopen %+v", rules)
+	}
+
+	missing, err := store.GetOne(context.Background(), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing != nil {
+		t.Errorf("Expected deleted rule to be missing, got %+v", missing)
+	}
+}
+
+func TestSqliteStoreHistory(t *testing.T) {
+	store, err := NewSqliteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	listing := Listing{Id: "1", Price: 100}
+	for _, price := range []float64{100, 100, 90} {
+		listing.Price = price
+		err = store.RecordListings(context.Background(), "a", []Listing{listing})
+		if err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Hour)
+	}
+
+	history, err := store.GetListingHistory(context.Background(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[1].Listing.Price != 90 {
+		t.Errorf("Expected two price observations, got %+v", history)
+	}
+
+	seen, err := store.IsSeen(context.Background(), "a", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !seen {
+		t.Error("Expected listing to be seen")
+	}
+
+	email := Email{To: "a@example.com", Rule: "a", Listing: listing}
+	err = store.RecordDelivered(context.Background(), []Email{email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivered, err := store.IsDelivered(context.Background(), email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !delivered {
+		t.Error("Expected email to be delivered")
+	}
+}
@@ -0,0 +1,110 @@
+package reporter
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+const (
+	awsStorage    = "aws"
+	localStorage  = "local"
+	sqliteStorage = "sqlite"
+)
+
+func NewRulesStoreFromEnv() (RulesStore, error) {
+	switch storage := getStorage(); storage {
+	case awsStorage:
+		awsSess, err := session.NewSession()
+		if err != nil {
+			return nil, fmt.Errorf("aws session creation failed: %w", err)
+		}
+		return NewDynamoRulesStore(awsSess), nil
+	case localStorage:
+		return NewFileRulesStore(cmp.Or(os.Getenv("RULES_FILE"), "rules.json")), nil
+	case sqliteStorage:
+		store, err := openSqliteStoreFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown storage: %s", storage)
+	}
+}
+
+func NewCredentialsStoreFromEnv() (CredentialsStore, error) {
+	switch storage := getStorage(); storage {
+	case awsStorage:
+		awsSess, err := session.NewSession()
+		if err != nil {
+			return nil, fmt.Errorf("aws session creation failed: %w", err)
+		}
+		return NewCredentialsBucket(awsSess), nil
+	case localStorage, sqliteStorage:
+		return NewCredentialsDir(cmp.Or(os.Getenv("CREDENTIALS_DIR"), ".")), nil
+	default:
+		return nil, fmt.Errorf("unknown storage: %s", storage)
+	}
+}
+
+func NewOutboxFromEnv() (Outbox, error) {
+	switch storage := getStorage(); storage {
+	case awsStorage:
+		awsSess, err := session.NewSession()
+		if err != nil {
+			return nil, fmt.Errorf("aws session creation failed: %w", err)
+		}
+		return NewDynamoOutbox(awsSess), nil
+	case localStorage:
+		return NewFileOutbox(cmp.Or(os.Getenv("OUTBOX_FILE"), "outbox.json")), nil
+	case sqliteStorage:
+		store, err := openSqliteStoreFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown storage: %s", storage)
+	}
+}
+
+// The rules store and the outbox share one sqlite handle per process, so
+// migrations run once and the file has a single writer.
+var (
+	sqliteMu    sync.Mutex
+	sqliteStore *SqliteStore
+)
+
+func openSqliteStoreFromEnv() (*SqliteStore, error) {
+	sqliteMu.Lock()
+	defer sqliteMu.Unlock()
+	if sqliteStore == nil {
+		store, err := NewSqliteStore(cmp.Or(os.Getenv("SQLITE_PATH"), "listing-reporter.db"))
+		if err != nil {
+			return nil, err
+		}
+		sqliteStore = store
+	}
+	return sqliteStore, nil
+}
+
+// CloseStores closes the stores opened from the environment that hold
+// resources. It is safe to call when none were opened.
+func CloseStores() error {
+	sqliteMu.Lock()
+	defer sqliteMu.Unlock()
+	if sqliteStore == nil {
+		return nil
+	}
+	err := sqliteStore.Close()
+	sqliteStore = nil
+	return err
+}
+
+func getStorage() string {
+	return cmp.Or(os.Getenv("STORAGE"), awsStorage)
+}
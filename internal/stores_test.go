@@ -0,0 +1,36 @@
+package reporter
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSqliteStoresShareOneHandle(t *testing.T) {
+	t.Setenv("STORAGE", sqliteStorage)
+	t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "test.db"))
+	defer CloseStores()
+
+	rules, err := NewRulesStoreFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox, err := NewOutboxFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules.(*SqliteStore) != outbox.(*SqliteStore) {
+		t.Error("Expected rules and outbox to share one sqlite store")
+	}
+
+	err = CloseStores()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewRulesStoreFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.(*SqliteStore) == rules.(*SqliteStore) {
+		t.Error("Expected a closed store to be opened again")
+	}
+}
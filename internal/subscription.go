@@ -0,0 +1,195 @@
+package reporter
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	unsubscribeAction = "unsubscribe"
+	pauseAction       = "pause"
+	pauseDuration     = 7 * 24 * time.Hour
+)
+
+type SubscriptionLinks struct {
+	baseUrl string
+	secret  []byte
+}
+
+func NewSubscriptionLinks(baseUrl string, secret []byte) *SubscriptionLinks {
+	return &SubscriptionLinks{
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		secret:  secret,
+	}
+}
+
+func NewSubscriptionLinksFromEnv() *SubscriptionLinks {
+	baseUrl := os.Getenv("SUBSCRIPTION_BASE_URL")
+	secret := os.Getenv("SUBSCRIPTION_SECRET")
+	if baseUrl == "" || secret == "" {
+		return nil
+	}
+	return NewSubscriptionLinks(baseUrl, []byte(secret))
+}
+
+func (s *SubscriptionLinks) UnsubscribeUrl(rule string, recipient string) string {
+	return s.url(unsubscribeAction, rule, recipient)
+}
+
+func (s *SubscriptionLinks) PauseUrl(rule string, recipient string) string {
+	return s.url(pauseAction, rule, recipient)
+}
+
+func (s *SubscriptionLinks) url(action string, rule string, recipient string) string {
+	query := url.Values{}
+	query.Set("rule", rule)
+	query.Set("email", recipient)
+	query.Set("token", s.token(action, rule, recipient))
+	return fmt.Sprintf("%s/%s?%s", s.baseUrl, action, query.Encode())
+}
+
+func (s *SubscriptionLinks) token(action string, rule string, recipient string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(action + "\n" + rule + "\n" + recipient))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *SubscriptionLinks) verify(action string, rule string, recipient string, token string) bool {
+	expected := s.token(action, rule, recipient)
+	return hmac.Equal([]byte(expected), []byte(token))
+}
+
+type SubscriptionHandler struct {
+	links      *SubscriptionLinks
+	rulesStore RulesStore
+}
+
+func NewSubscriptionHandler(links *SubscriptionLinks, rulesStore RulesStore) *SubscriptionHandler {
+	return &SubscriptionHandler{links: links, rulesStore: rulesStore}
+}
+
+var confirmTemplate = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Listing Reporter</title></head>
+<body>
+  <form method="post" action="{{.Action}}">
+    <p>{{.Question}}</p>
+    <button type="submit">{{.Button}}</button>
+  </form>
+</body>
+</html>
+`))
+
+type confirmPage struct {
+	Action   string
+	Question string
+	Button   string
+}
+
+// ServeHTTP only changes the rule on POST, which is what one-click
+// unsubscribe (RFC 8058) sends. GET renders a confirmation form, so link
+// scanners and prefetchers opening the link change nothing.
+func (h *SubscriptionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	action := strings.Trim(r.URL.Path, "/")
+	if action != unsubscribeAction && action != pauseAction {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	ruleName := query.Get("rule")
+	recipient := query.Get("email")
+	token := query.Get("token")
+
+	if !h.links.verify(action, ruleName, recipient, token) {
+		http.Error(w, "invalid link", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		page := confirmPage{Action: r.URL.RequestURI()}
+		switch action {
+		case unsubscribeAction:
+			page.Question = fmt.Sprintf("Unsubscribe %s from search %s?", recipient, ruleName)
+			page.Button = "Unsubscribe"
+		case pauseAction:
+			page.Question = fmt.Sprintf("Pause alerts for search %s for %d days?", ruleName, int(pauseDuration.Hours()/24))
+			page.Button = "Pause"
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := confirmTemplate.Execute(w, page)
+		if err != nil {
+			slog.Error("subscription template rendering failed", "error", err)
+		}
+		return
+	}
+
+	rule, err := h.rulesStore.GetOne(r.Context(), ruleName)
+	if err != nil {
+		slog.Error("subscription failed", "action", action, "rule", ruleName, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if rule == nil || rule.Email != recipient {
+		fmt.Fprintln(w, "You are not subscribed to this search.")
+		return
+	}
+
+	pausedUntil := time.Now().Add(pauseDuration)
+	switch action {
+	case unsubscribeAction:
+		err = h.rulesStore.Delete(r.Context(), ruleName)
+	case pauseAction:
+		err = h.pause(r.Context(), *rule, pausedUntil)
+	}
+	if err != nil {
+		slog.Error("subscription failed", "action", action, "rule", ruleName, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	switch action {
+	case unsubscribeAction:
+		fmt.Fprintln(w, "You have been unsubscribed.")
+	case pauseAction:
+		fmt.Fprintf(w, "Alerts paused until %s.\n", pausedUntil.Format(time.DateOnly))
+	}
+}
+
+// pause re-reads the rule and retries once when a run wrote its state in the
+// meantime.
+func (h *SubscriptionHandler) pause(ctx context.Context, rule RetrievalRule, pausedUntil time.Time) error {
+	state := rule.State()
+	state.PausedUntil = &pausedUntil
+	err := h.rulesStore.PutState(ctx, rule.Name, state, rule.StateVersion)
+	if !errors.Is(err, ErrVersionConflict) {
+		return err
+	}
+
+	stored, err := h.rulesStore.GetOne(ctx, rule.Name)
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		return fmt.Errorf("rule %s: %w", rule.Name, ErrVersionConflict)
+	}
+	state = stored.State()
+	state.PausedUntil = &pausedUntil
+	return h.rulesStore.PutState(ctx, rule.Name, state, stored.StateVersion)
+}
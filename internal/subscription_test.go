@@ -0,0 +1,120 @@
+package reporter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSubscriptionLinks(t *testing.T) {
+	links := NewSubscriptionLinks("https://example.com/", []byte("secret"))
+
+	unsubscribeUrl, err := url.Parse(links.UnsubscribeUrl("flat", "user@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unsubscribeUrl.Path != "/unsubscribe" {
+		t.Errorf("Expected /unsubscribe path, got %s", unsubscribeUrl.Path)
+	}
+
+	token := unsubscribeUrl.Query().Get("token")
+	if !links.verify(unsubscribeAction, "flat", "user@example.com", token) {
+		t.Error("Expected unsubscribe token to verify")
+	}
+	if links.verify(pauseAction, "flat", "user@example.com", token) {
+		t.Error("Expected unsubscribe token to be rejected for pause")
+	}
+	if links.verify(unsubscribeAction, "flat", "other@example.com", token) {
+		t.Error("Expected token to be rejected for other recipient")
+	}
+
+	otherLinks := NewSubscriptionLinks("https://example.com", []byte("other"))
+	if otherLinks.verify(unsubscribeAction, "flat", "user@example.com", token) {
+		t.Error("Expected token to be rejected for other secret")
+	}
+}
+
+// conflictOnceStore lets a run write the rule state just before the first
+// state write.
+type conflictOnceStore struct {
+	*memRulesStore
+	raced bool
+}
+
+func (s *conflictOnceStore) PutState(ctx context.Context, name string, state RuleState, version int) error {
+	if !s.raced {
+		s.raced = true
+		err := s.memRulesStore.PutState(ctx, name, RuleState{Cutoffs: []string{"run"}}, version)
+		if err != nil {
+			return err
+		}
+	}
+	return s.memRulesStore.PutState(ctx, name, state, version)
+}
+
+func TestSubscriptionHandler(t *testing.T) {
+	ctx := context.Background()
+	links := NewSubscriptionLinks("https://example.com", []byte("secret"))
+	rules := &memRulesStore{}
+	for _, name := range []string{"flat", "house"} {
+		err := rules.Put(ctx, RetrievalRule{Name: name, Email: "user@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := NewSubscriptionHandler(links, &conflictOnceStore{memRulesStore: rules})
+
+	request := func(method string, link string) string {
+		req := httptest.NewRequest(method, link, strings.NewReader("List-Unsubscribe=One-Click"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected status 200 for %s %s, got %d", method, link, rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	unsubscribeUrl := links.UnsubscribeUrl("flat", "user@example.com")
+	pauseUrl := links.PauseUrl("house", "user@example.com")
+	for _, link := range []string{unsubscribeUrl, pauseUrl} {
+		body := request(http.MethodGet, link)
+		if !strings.Contains(body, `<form method="post"`) {
+			t.Errorf("Expected confirmation form, got %s", body)
+		}
+	}
+	all, err := rules.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].PausedUntil != nil || all[1].PausedUntil != nil {
+		t.Errorf("Expected GET to change nothing, got %+v", all)
+	}
+
+	body := request(http.MethodPost, pauseUrl)
+	if !strings.Contains(body, "Alerts paused until") {
+		t.Errorf("Expected pause confirmation, got %s", body)
+	}
+	house, err := rules.GetOne(ctx, "house")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if house.PausedUntil == nil || len(house.Cutoffs) != 1 {
+		t.Errorf("Expected pause to be retried on top of the run's state, got %+v", house)
+	}
+
+	body = request(http.MethodPost, unsubscribeUrl)
+	if !strings.Contains(body, "You have been unsubscribed.") {
+		t.Errorf("Expected unsubscribe confirmation, got %s", body)
+	}
+	flat, err := rules.GetOne(ctx, "flat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flat != nil {
+		t.Errorf("Expected rule to be deleted, got %+v", flat)
+	}
+}
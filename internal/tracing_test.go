@@ -0,0 +1,55 @@
+package reporter
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestDrainOutboxSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	outbox := NewFileOutbox(filepath.Join(t.TempDir(), "outbox.json"))
+	err := outbox.Enqueue(context.Background(), NewOutboxItems([]Email{{To: "a@example.com", Rule: "a", Listing: Listing{Id: "1"}}}, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, root := tracer.Start(context.Background(), "run")
+	err = DrainOutbox(ctx, outbox, &fakeSender{}, []string{"a"}, &RunReport{})
+	root.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	send, ok := spans["send"]
+	if !ok || spans["outbox.pending"] == nil {
+		t.Fatalf("Expected pending and send spans, got %v", spans)
+	}
+	if send.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Error("Expected send span to be a child of the run span")
+	}
+	if !hasAttribute(send, attribute.String("listing_id", "1")) {
+		t.Errorf("Expected listing_id attribute, got %v", send.Attributes())
+	}
+}
+
+func hasAttribute(span sdktrace.ReadOnlySpan, expected attribute.KeyValue) bool {
+	for _, attr := range span.Attributes() {
+		if attr == expected {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,321 @@
+package reporter
+
+import (
+	"cmp"
+	"crypto/subtle"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+//go:embed templates
+var templatesFs embed.FS
+
+var ruleTemplate = template.Must(template.ParseFS(templatesFs, "templates/rule.html"))
+
+type WebHandler struct {
+	rulesStore RulesStore
+	password   string
+	mux        *http.ServeMux
+}
+
+type ruleForm struct {
+	Name          string
+	Email         string
+	Url           string
+	PriceFrom     string
+	PriceTo       string
+	RoomsFrom     string
+	RoomsTo       string
+	AreaFrom      string
+	AreaTo        string
+	FloorFrom     string
+	FloorTo       string
+	IsNotTopFloor bool
+	Version       int
+}
+
+type rulePage struct {
+	Form     ruleForm
+	Rules    []RetrievalRule
+	Listings []Listing
+	Preview  bool
+	Error    string
+	Message  string
+}
+
+func NewWebHandler(rulesStore RulesStore, password string) *WebHandler {
+	h := &WebHandler{rulesStore: rulesStore, password: password, mux: http.NewServeMux()}
+
+	h.mux.HandleFunc("GET /ui/{$}", h.index)
+	h.mux.HandleFunc("POST /ui/preview", h.preview)
+	h.mux.HandleFunc("POST /ui/save", h.save)
+
+	return h
+}
+
+func (h *WebHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, password, ok := r.BasicAuth()
+	if !ok || h.password == "" || subtle.ConstantTimeCompare([]byte(password), []byte(h.password)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="listing-reporter"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	// Browsers resend Basic credentials on cross-site form posts.
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && isCrossOrigin(r) {
+		http.Error(w, "cross-origin request rejected", http.StatusForbidden)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// isCrossOrigin checks Sec-Fetch-Site, or Origin for browsers that don't
+// send it. Requests with neither come from non-browser clients.
+func isCrossOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site != "same-origin" && site != "none"
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+func (h *WebHandler) index(w http.ResponseWriter, r *http.Request) {
+	page := rulePage{}
+
+	name := r.URL.Query().Get("rule")
+	if name != "" {
+		rule, err := h.rulesStore.GetOne(r.Context(), name)
+		if err != nil {
+			page.Error = fmt.Sprintf("failed to load rule: %s", err)
+		} else if rule == nil {
+			page.Error = fmt.Sprintf("rule %s not found", name)
+		} else {
+			page.Form = newRuleForm(*rule)
+		}
+	}
+
+	h.render(w, r, page)
+}
+
+func (h *WebHandler) preview(w http.ResponseWriter, r *http.Request) {
+	form := parseRuleForm(r)
+	page := rulePage{Form: form}
+
+	rule, err := form.rule()
+	if err != nil {
+		page.Error = err.Error()
+		h.render(w, r, page)
+		return
+	}
+
+	page.Form = newRuleForm(rule)
+
+	listings, err := PreviewRule(r.Context(), rule)
+	if err != nil {
+		page.Error = err.Error()
+		h.render(w, r, page)
+		return
+	}
+
+	page.Listings = listings
+	page.Preview = true
+	h.render(w, r, page)
+}
+
+func (h *WebHandler) save(w http.ResponseWriter, r *http.Request) {
+	form := parseRuleForm(r)
+	page := rulePage{Form: form}
+
+	rule, err := form.rule()
+	if err != nil {
+		page.Error = err.Error()
+		h.render(w, r, page)
+		return
+	}
+
+	existing, err := h.rulesStore.GetOne(r.Context(), rule.Name)
+	if err != nil {
+		page.Error = fmt.Sprintf("failed to load rule: %s", err)
+		h.render(w, r, page)
+		return
+	}
+	// The form only owns some of the fields, the rest are kept as stored.
+	if existing != nil {
+		rule = existing.withForm(rule)
+	}
+
+	err = rule.Validate()
+	if err != nil {
+		page.Error = err.Error()
+		h.render(w, r, page)
+		return
+	}
+
+	err = h.rulesStore.Put(r.Context(), rule)
+	if errors.Is(err, ErrVersionConflict) {
+		page.Error = fmt.Sprintf("rule %s was changed since it was loaded, reload it and try again", rule.Name)
+		h.render(w, r, page)
+		return
+	}
+	if err != nil {
+		page.Error = fmt.Sprintf("failed to save rule: %s", err)
+		h.render(w, r, page)
+		return
+	}
+
+	// The form carries the stored version into the next save.
+	saved, err := h.rulesStore.GetOne(r.Context(), rule.Name)
+	if err == nil && saved != nil {
+		rule = *saved
+	}
+	page.Form = newRuleForm(rule)
+	page.Message = fmt.Sprintf("Rule %s saved.", rule.Name)
+	h.render(w, r, page)
+}
+
+func (h *WebHandler) render(w http.ResponseWriter, r *http.Request, page rulePage) {
+	rules, err := h.rulesStore.Get(r.Context())
+	if err != nil {
+		slog.Error("web rules listing failed", "error", err)
+	}
+	page.Rules = rules
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = ruleTemplate.Execute(w, page)
+	if err != nil {
+		slog.Error("web template rendering failed", "error", err)
+	}
+}
+
+func parseRuleForm(r *http.Request) ruleForm {
+	// A missing or invalid version saves as a new rule, which fails if the
+	// name is taken.
+	version, _ := strconv.Atoi(r.PostFormValue("version"))
+	get := func(key string) string {
+		return strings.TrimSpace(r.PostFormValue(key))
+	}
+	return ruleForm{
+		Name:          get("name"),
+		Email:         get("email"),
+		Url:           get("url"),
+		PriceFrom:     get("price_from"),
+		PriceTo:       get("price_to"),
+		RoomsFrom:     get("rooms_from"),
+		RoomsTo:       get("rooms_to"),
+		AreaFrom:      get("area_from"),
+		AreaTo:        get("area_to"),
+		FloorFrom:     get("floor_from"),
+		FloorTo:       get("floor_to"),
+		IsNotTopFloor: r.PostFormValue("not_top_floor") != "",
+		Version:       version,
+	}
+}
+
+func newRuleForm(rule RetrievalRule) ruleForm {
+	form := ruleForm{
+		Name:    rule.Name,
+		Email:   rule.Email,
+		Url:     baseUrl + rule.Url,
+		Version: rule.Version,
+	}
+	form.PriceFrom, form.PriceTo = formatRange(rule.Filters.Price)
+	form.RoomsFrom, form.RoomsTo = formatRange(rule.Filters.Rooms)
+	form.AreaFrom, form.AreaTo = formatRange(rule.Filters.Area)
+	form.FloorFrom, form.FloorTo = formatRange(rule.Filters.Floor)
+	form.IsNotTopFloor = rule.Filters.IsNotTopFloor != nil && *rule.Filters.IsNotTopFloor
+	return form
+}
+
+func (f ruleForm) rule() (RetrievalRule, error) {
+	rule := RetrievalRule{Name: f.Name, Email: f.Email, Version: f.Version}
+
+	path, urlFilters, err := FiltersFromUrl(f.Url)
+	if err != nil {
+		return rule, err
+	}
+	rule.Url = path
+
+	rule.Filters.Price, err = parseRange("price", f.PriceFrom, f.PriceTo, parseFloat)
+	if err != nil {
+		return rule, err
+	}
+	rule.Filters.Rooms, err = parseRange("rooms", f.RoomsFrom, f.RoomsTo, strconv.Atoi)
+	if err != nil {
+		return rule, err
+	}
+	rule.Filters.Area, err = parseRange("area", f.AreaFrom, f.AreaTo, parseFloat)
+	if err != nil {
+		return rule, err
+	}
+	rule.Filters.Floor, err = parseRange("floor", f.FloorFrom, f.FloorTo, strconv.Atoi)
+	if err != nil {
+		return rule, err
+	}
+	rule.Filters.Price = cmp.Or(rule.Filters.Price, urlFilters.Price)
+	rule.Filters.Rooms = cmp.Or(rule.Filters.Rooms, urlFilters.Rooms)
+	rule.Filters.Area = cmp.Or(rule.Filters.Area, urlFilters.Area)
+	rule.Filters.Floor = cmp.Or(rule.Filters.Floor, urlFilters.Floor)
+	if f.IsNotTopFloor {
+		isNotTopFloor := true
+		rule.Filters.IsNotTopFloor = &isNotTopFloor
+	}
+
+	return rule, nil
+}
+
+func (r RetrievalRule) withForm(form RetrievalRule) RetrievalRule {
+	r.Email = form.Email
+	r.Url = form.Url
+	r.Filters = form.Filters
+	r.Version = form.Version
+	return r
+}
+
+func parseFloat(s string) (float64, error) {
+	return strconv.ParseFloat(s, 64)
+}
+
+func parseRange[T int | float64](name string, from string, to string, parse func(string) (T, error)) (*RangeFilter[T], error) {
+	if from == "" && to == "" {
+		return nil, nil
+	}
+	filter := &RangeFilter[T]{}
+	if from != "" {
+		val, err := parse(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s from: %s", name, from)
+		}
+		filter.From = &val
+	}
+	if to != "" {
+		val, err := parse(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s to: %s", name, to)
+		}
+		filter.To = &val
+	}
+	return filter, nil
+}
+
+func formatRange[T int | float64](filter *RangeFilter[T]) (string, string) {
+	if filter == nil {
+		return "", ""
+	}
+	format := func(val *T) string {
+		if val == nil {
+			return ""
+		}
+		return fmt.Sprint(*val)
+	}
+	return format(filter.From), format(filter.To)
+}
@@ -0,0 +1,143 @@
+package reporter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRuleFormRoundTrip(t *testing.T) {
+	form := ruleForm{
+		Name:          "flat",
+		Email:         "user@example.com",
+		Url:           "https://www.ss.lv/lv/real-estate/flats/riga/centre/sell/",
+		PriceFrom:     "50000",
+		RoomsFrom:     "2",
+		RoomsTo:       "3",
+		AreaTo:        "80.5",
+		IsNotTopFloor: true,
+	}
+
+	rule, err := form.rule()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rule.Url != "/lv/real-estate/flats/riga/centre/sell/" {
+		t.Errorf("Expected ss.lv path, got %s", rule.Url)
+	}
+	if rule.Filters.Price == nil || *rule.Filters.Price.From != 50000 || rule.Filters.Price.To != nil {
+		t.Errorf("Unexpected price filter %+v", rule.Filters.Price)
+	}
+	if rule.Filters.Floor != nil {
+		t.Errorf("Expected no floor filter, got %+v", rule.Filters.Floor)
+	}
+
+	roundTrip := newRuleForm(rule)
+	if roundTrip != form {
+		t.Errorf("Expected %+v, got %+v", form, roundTrip)
+	}
+}
+
+func TestRuleFormInvalid(t *testing.T) {
+	invalid := []ruleForm{
+		{Url: "https://example.com/lv/"},
+		{Url: "/lv/", RoomsFrom: "two"},
+	}
+	for _, form := range invalid {
+		if _, err := form.rule(); err == nil {
+			t.Errorf("Expected error for form %+v", form)
+		}
+	}
+}
+
+func TestWebHandlerSaveKeepsOtherFields(t *testing.T) {
+	disabled := false
+	rules := &memRulesStore{}
+	err := rules.Put(context.Background(), RetrievalRule{
+		Name:          "flat",
+		Email:         "user@example.com",
+		Url:           "/lv/real-estate/flats/riga/centre/sell/",
+		Enabled:       &disabled,
+		Tags:          []string{"riga"},
+		Owner:         "owner@example.com",
+		CheckInterval: "30m",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{
+		"name":     {"flat"},
+		"email":    {"other@example.com"},
+		"url":      {"https://www.ss.lv/lv/real-estate/flats/riga/centre/sell/"},
+		"rooms_to": {"2"},
+		"version":  {"1"},
+	}
+	handler := NewWebHandler(rules, "secret")
+
+	body := postWebForm(handler, "/ui/save", form)
+	if !strings.Contains(body, "Rule flat saved.") {
+		t.Fatalf("Expected rule to be saved, got %s", body)
+	}
+	body = postWebForm(handler, "/ui/save", form)
+	if !strings.Contains(body, "was changed since it was loaded") {
+		t.Errorf("Expected a stale version to be rejected, got %s", body)
+	}
+	form.Del("version")
+	body = postWebForm(handler, "/ui/save", form)
+	if !strings.Contains(body, "was changed since it was loaded") {
+		t.Errorf("Expected a save without version not to overwrite the rule, got %s", body)
+	}
+
+	rule, err := rules.GetOne(context.Background(), "flat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Email != "other@example.com" || rule.Filters.Rooms == nil {
+		t.Errorf("Expected form fields to be saved, got %+v", rule)
+	}
+	if rule.IsEnabled() || !slices.Equal(rule.Tags, []string{"riga"}) || rule.Owner != "owner@example.com" || rule.CheckInterval != "30m" {
+		t.Errorf("Expected fields outside the form to be kept, got %+v", rule)
+	}
+}
+
+func postWebForm(handler http.Handler, path string, form url.Values) string {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("", "secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Body.String()
+}
+
+func TestWebHandlerRejectsCrossOriginPosts(t *testing.T) {
+	handler := NewWebHandler(&memRulesStore{}, "secret")
+
+	cases := []struct {
+		header string
+		value  string
+		status int
+	}{
+		{"Sec-Fetch-Site", "cross-site", http.StatusForbidden},
+		{"Sec-Fetch-Site", "same-site", http.StatusForbidden},
+		{"Sec-Fetch-Site", "same-origin", http.StatusOK},
+		{"Origin", "https://evil.example.com", http.StatusForbidden},
+		{"Origin", "http://example.com", http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/ui/save", strings.NewReader(""))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set(c.header, c.value)
+		req.SetBasicAuth("", "secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != c.status {
+			t.Errorf("Expected status %d for %s %s, got %d", c.status, c.header, c.value, rec.Code)
+		}
+	}
+}
@@ -28,6 +28,16 @@ variable "aws_region" {
   type = string
 }
 
+variable "api_token" {
+  type      = string
+  sensitive = true
+}
+
+variable "subscription_secret" {
+  type      = string
+  sensitive = true
+}
+
 locals {
   common_tags = {
     Project   = "ListingReporter"
@@ -70,6 +80,35 @@ resource "aws_dynamodb_table" "table" {
   tags = local.common_tags
 }
 
+resource "aws_dynamodb_table" "outbox_table" {
+  name         = "${var.name_prefix}-outbox"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "Key"
+
+  attribute {
+    name = "Key"
+    type = "S"
+  }
+
+  attribute {
+    name = "PendingRule"
+    type = "S"
+  }
+
+  global_secondary_index {
+    name            = "pending-index"
+    hash_key        = "PendingRule"
+    projection_type = "ALL"
+  }
+
+  ttl {
+    attribute_name = "ExpiresAt"
+    enabled        = true
+  }
+
+  tags = local.common_tags
+}
+
 resource "aws_cloudwatch_log_group" "lambda_log_group" {
   name              = "/aws/lambda/${aws_lambda_function.lambda.function_name}"
   retention_in_days = 7
@@ -107,15 +146,20 @@ resource "aws_iam_role_policy" "lambda_policy" {
         Action = [
           "logs:CreateLogStream",
           "logs:PutLogEvents",
+          "dynamodb:GetItem",
           "dynamodb:PutItem",
+          "dynamodb:DeleteItem",
           "dynamodb:BatchWriteItem",
           "dynamodb:Scan",
+          "dynamodb:Query",
           "dynamodb:UpdateItem",
           "s3:GetObject"
         ],
         Resource = [
           "arn:aws:logs:${var.aws_region}:${data.aws_caller_identity.current.account_id}:log-group:${aws_cloudwatch_log_group.lambda_log_group.name}*",
           "arn:aws:dynamodb:${var.aws_region}:${data.aws_caller_identity.current.account_id}:table/${aws_dynamodb_table.table.name}",
+          "arn:aws:dynamodb:${var.aws_region}:${data.aws_caller_identity.current.account_id}:table/${aws_dynamodb_table.outbox_table.name}",
+          "arn:aws:dynamodb:${var.aws_region}:${data.aws_caller_identity.current.account_id}:table/${aws_dynamodb_table.outbox_table.name}/index/*",
           "arn:aws:s3:::${aws_s3_bucket.bucket.bucket}/*"
         ]
       }
@@ -141,10 +185,17 @@ resource "aws_lambda_function" "lambda" {
     size = 512
   }
 
-  timeout          = 15
+  timeout          = 60
   filename         = "lambda_function_payload.zip"
   source_code_hash = data.archive_file.lambda.output_base64sha256
 
+  environment {
+    variables = {
+      SUBSCRIPTION_BASE_URL = aws_lambda_function_url.api_lambda_url.function_url
+      SUBSCRIPTION_SECRET   = var.subscription_secret
+    }
+  }
+
   tags = local.common_tags
 }
 
@@ -166,3 +217,67 @@ resource "aws_lambda_permission" "allow_cloudwatch_to_invoke" {
   principal     = "events.amazonaws.com"
   source_arn    = aws_cloudwatch_event_rule.lambda_schedule.arn
 }
+
+data "archive_file" "api_lambda" {
+  type        = "zip"
+  source_file = "api/bootstrap"
+  output_path = "api_lambda_function_payload.zip"
+}
+
+resource "aws_cloudwatch_log_group" "api_lambda_log_group" {
+  name              = "/aws/lambda/${aws_lambda_function.api_lambda.function_name}"
+  retention_in_days = 7
+
+  tags = local.common_tags
+}
+
+resource "aws_iam_role_policy" "api_lambda_policy" {
+  role = aws_iam_role.lambda_execution_role.id
+
+  policy = jsonencode({
+    Version = "2012-10-17",
+    Statement = [
+      {
+        Effect = "Allow",
+        Action = [
+          "logs:CreateLogStream",
+          "logs:PutLogEvents"
+        ],
+        Resource = [
+          "arn:aws:logs:${var.aws_region}:${data.aws_caller_identity.current.account_id}:log-group:${aws_cloudwatch_log_group.api_lambda_log_group.name}*"
+        ]
+      }
+    ]
+  })
+}
+
+resource "aws_lambda_function" "api_lambda" {
+  function_name = "${var.name_prefix}-api-lambda-function"
+  role          = aws_iam_role.lambda_execution_role.arn
+  handler       = "bootstrap"
+  runtime       = "provided.al2023"
+  architectures = ["arm64"]
+  memory_size   = 128
+
+  timeout          = 15
+  filename         = "api_lambda_function_payload.zip"
+  source_code_hash = data.archive_file.api_lambda.output_base64sha256
+
+  environment {
+    variables = {
+      API_TOKEN           = var.api_token
+      SUBSCRIPTION_SECRET = var.subscription_secret
+    }
+  }
+
+  tags = local.common_tags
+}
+
+resource "aws_lambda_function_url" "api_lambda_url" {
+  function_name      = aws_lambda_function.api_lambda.function_name
+  authorization_type = "NONE"
+}
+
+output "api_url" {
+  value = aws_lambda_function_url.api_lambda_url.function_url
+}